@@ -0,0 +1,80 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"runtime"
+	"time"
+)
+
+// runBench mines synthetic blocks for a while and reports the hash rate,
+// e.g. `kubechain bench -duration 30s -workers 8`.
+func runBench(args []string) {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	duration := flags.Duration("duration", 10*time.Second, "how long to mine for")
+	workers := flags.Int("workers", runtime.GOMAXPROCS(0), "number of goroutines mining in parallel")
+	difficulty := flags.Int64("difficulty", v1alpha1.DefaultDifficulty, "number of leading zero bits required from block hashes")
+	flags.Parse(args)
+
+	if !v1alpha1.ValidDifficulty(*difficulty) {
+		log.Fatalf("difficulty must be between %d and %d", v1alpha1.MinDifficulty, v1alpha1.MaxDifficulty)
+	}
+
+	progress := make(chan v1alpha1.Progress, 1)
+	miner := &v1alpha1.Miner{
+		Workers:  *workers,
+		Progress: progress,
+	}
+	go func() {
+		for p := range progress {
+			log.Printf("%d hashes in %v (%.0f hashes/s)", p.Hashes, p.Elapsed, float64(p.Hashes)/p.Elapsed.Seconds())
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+
+	log.Printf("mining for %v with %d workers", *duration, *workers)
+	start := time.Now()
+	blocks := 0
+	for ctx.Err() == nil {
+		block := &v1alpha1.Block{
+			Spec: v1alpha1.BlockSpec{
+				Data:       fmt.Sprintf("kubechain bench block %d", blocks),
+				Timestamp:  time.Now().Unix(),
+				Difficulty: *difficulty,
+			},
+		}
+		if _, _, err := miner.Run(ctx, v1alpha1.NewProofOfWork(block)); err == nil {
+			blocks++
+		}
+	}
+	elapsed := time.Since(start)
+	close(progress)
+
+	fmt.Printf("workers:      %d\n", *workers)
+	fmt.Printf("difficulty:   %d\n", *difficulty)
+	fmt.Printf("duration:     %v\n", elapsed)
+	fmt.Printf("hashes:       %d\n", miner.Hashes())
+	fmt.Printf("hashes/s:     %.0f\n", float64(miner.Hashes())/elapsed.Seconds())
+	fmt.Printf("blocks mined: %d\n", blocks)
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/nimrodshn/kubechain/pkg/consensus"
+	"golang.org/x/crypto/ed25519"
+
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+)
+
+// runKeygen generates an ed25519 key pair for a proof of authority signer and
+// prints the Secret holding its private key along with the entry to add to the
+// signers of the blockchain, e.g. `kubechain keygen -name alice | kubectl create -f -`.
+func runKeygen(args []string) {
+	flags := flag.NewFlagSet("keygen", flag.ExitOnError)
+	name := flags.String("name", "", "name of the signer")
+	secret := flags.String("secret", "", "name of the Secret holding the private key, defaults to <name>-signer")
+	flags.Parse(args)
+
+	if *name == "" {
+		log.Fatalf("the name of the signer is required")
+	}
+	if *secret == "" {
+		*secret = *name + "-signer"
+	}
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatalf("failed to generate key: %v", err)
+	}
+
+	fmt.Printf("# Add the signer to spec.authority.signers of the blockchain:\n")
+	fmt.Printf("#   - name: %q\n", *name)
+	fmt.Printf("#     publicKey: %q\n", base64.StdEncoding.EncodeToString(public))
+	fmt.Printf("apiVersion: v1\n")
+	fmt.Printf("kind: Secret\n")
+	fmt.Printf("metadata:\n")
+	fmt.Printf("  name: %q\n", *secret)
+	fmt.Printf("type: Opaque\n")
+	fmt.Printf("data:\n")
+	fmt.Printf("  %s: %q\n", consensus.SignerKeySecretKey, base64.StdEncoding.EncodeToString(private))
+}
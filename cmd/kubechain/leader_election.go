@@ -0,0 +1,71 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"context"
+	"log"
+	"os"
+)
+
+// runLeaderElection campaigns for the controller Lease and calls run while this
+// replica holds it, the standby replicas wait for the Lease to expire. A replica
+// which loses the Lease exits rather than risk mining alongside the new leader,
+// the new leader recovers the blockchains from the blocks persisted in the API server.
+func runLeaderElection(kubeClient kubernetes.Interface, run func(stopCh <-chan struct{})) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("failed to get hostname: %v", err)
+	}
+	// Several replicas may run on the same host.
+	identity := hostname + "_" + string(uuid.NewUUID())
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: leaseNamespace,
+			Name:      leaseName,
+		},
+		Client:     kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+
+	leaderelection.RunOrDie(context.Background(), leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: leaseDuration,
+		RenewDeadline: renewDeadline,
+		RetryPeriod:   retryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Printf("%s acquired lease %s/%s, starting the controller", identity, leaseNamespace, leaseName)
+				run(ctx.Done())
+			},
+			OnStoppedLeading: func() {
+				log.Fatalf("%s lost lease %s/%s, exiting", identity, leaseNamespace, leaseName)
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					log.Printf("%s is the leader, waiting as a standby", leader)
+				}
+			},
+		},
+	})
+}
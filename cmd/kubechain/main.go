@@ -19,14 +19,29 @@ import (
 	clientset "github.com/nimrodshn/kubechain/pkg/clientset/v1alpha1"
 	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
 
+	"github.com/nimrodshn/kubechain/pkg/api"
+	"github.com/nimrodshn/kubechain/pkg/chain"
+	"github.com/nimrodshn/kubechain/pkg/consensus"
 	"github.com/nimrodshn/kubechain/pkg/controllers/blockchain"
+	"github.com/nimrodshn/kubechain/pkg/ledger"
+	"github.com/nimrodshn/kubechain/pkg/replication"
+	"github.com/nimrodshn/kubechain/pkg/wallet"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 
 	"flag"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
 
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 
 	"log"
@@ -34,6 +49,60 @@ import (
 
 var kubeconfig string
 
+// The address the API is served on, it is not served when empty.
+var apiAddress string
+
+// The validating admission webhook of blocks, it is not served when the address is empty.
+var (
+	webhookAddress       string
+	webhookCertSecret    string
+	webhookService       string
+	webhookConfiguration string
+)
+
+// The user the controller authenticates as, the only one allowed to seal blocks.
+var webhookControllerUser string
+
+// The number of goroutines used to mine a single block.
+var miningWorkers int
+
+// Limits of the pool of blocks whose parent is not known yet.
+var (
+	orphanPoolSize int
+	orphanTTL      time.Duration
+)
+
+// The address the rewards of the blocks mined by this replica are paid to.
+var rewardAddress string
+
+// How pending transactions are batched into blocks.
+var (
+	blockMaxTransactions int
+	blockInterval        time.Duration
+)
+
+// Raft replication of the blockchains across controller replicas.
+var (
+	raftEnabled         bool
+	raftID              string
+	raftBind            string
+	raftAdvertise       string
+	raftDataDir         string
+	raftPeers           string
+	raftPeerService     string
+	raftBootstrapExpect int
+)
+
+// Leader election of the replica running the controller.
+var (
+	leaderElect    bool
+	leaseName      string
+	leaseNamespace string
+	leaseDuration  time.Duration
+	renewDeadline  time.Duration
+	retryPeriod    time.Duration
+)
+
 // The number of threads to process events.
 const threadCount = 3
 
@@ -42,35 +111,91 @@ const defaultNamespace = "default"
 
 func init() {
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "path to Kubernetes config file")
+	flag.StringVar(&apiAddress, "api-address", ":8080", "address to serve the kubechain API on, e.g. merkle proofs, empty to disable it")
+	flag.StringVar(&webhookAddress, "webhook-address", "", "address to serve the validating admission webhook of blocks on over TLS, e.g. :8443, empty to disable it")
+	flag.StringVar(&webhookCertSecret, "webhook-cert-secret", "", "name of the kubernetes.io/tls Secret holding the webhook certificate, a self-signed certificate is generated when empty")
+	flag.StringVar(&webhookService, "webhook-service", "kubechain-webhook", "name of the Service the API server reaches the webhook through, self-generated certificates are issued for it")
+	flag.StringVar(&webhookConfiguration, "webhook-configuration", "kubechain", "name of the ValidatingWebhookConfiguration updated to trust self-generated certificates, empty to leave it alone")
+	flag.StringVar(&webhookControllerUser, "webhook-controller-user", "system:serviceaccount:default:default", "user the controller authenticates as, the only one allowed to change blocks while they are mined")
+	flag.IntVar(&miningWorkers, "mining-workers", runtime.GOMAXPROCS(0), "number of goroutines used to mine a single block")
+	flag.IntVar(&orphanPoolSize, "orphan-pool-size", 100, "maximum number of blocks held while waiting for their parent")
+	flag.DurationVar(&orphanTTL, "orphan-ttl", time.Hour, "how long a block is held while waiting for its parent")
+	flag.StringVar(&rewardAddress, "reward-address", "", "wallet address the rewards of the blocks mined on ledger chains are paid to, no reward is claimed when empty")
+	flag.IntVar(&blockMaxTransactions, "block-max-transactions", 100, "maximum number of pending transactions batched into a block")
+	flag.DurationVar(&blockInterval, "block-interval", 10*time.Second, "how often pending transactions are batched into a block")
+	flag.BoolVar(&raftEnabled, "raft", false, "replicate the blockchains across controller replicas with raft")
+	flag.StringVar(&raftID, "raft-id", "", "id of this replica in the raft cluster, defaults to its advertised address")
+	flag.StringVar(&raftBind, "raft-bind", ":7000", "address the raft transport listens on")
+	flag.StringVar(&raftAdvertise, "raft-advertise", "", "address the other replicas reach this one at, defaults to -raft-bind")
+	flag.StringVar(&raftDataDir, "raft-data-dir", "raft", "directory holding the raft log and snapshots")
+	flag.StringVar(&raftPeers, "raft-peers", "", "comma separated static list of the raft replicas, as id=host:port or host:port")
+	flag.StringVar(&raftPeerService, "raft-peer-service", "", "DNS name of a headless Service resolving to the raft replicas")
+	flag.IntVar(&raftBootstrapExpect, "raft-bootstrap-expect", 3, "number of replicas -raft-peer-service must resolve to before bootstrapping the cluster")
+	flag.BoolVar(&leaderElect, "leader-elect", false, "only run the controller in the replica holding the leader election lease")
+	flag.StringVar(&leaseName, "leader-elect-lease-name", "kubechain", "name of the leader election Lease")
+	flag.StringVar(&leaseNamespace, "leader-elect-lease-namespace", defaultNamespace, "namespace of the leader election Lease")
+	flag.DurationVar(&leaseDuration, "leader-elect-lease-duration", 15*time.Second, "how long standby replicas wait before taking over a lease which is not renewed")
+	flag.DurationVar(&renewDeadline, "leader-elect-renew-deadline", 10*time.Second, "how long the leader keeps retrying to renew its lease before giving up leadership")
+	flag.DurationVar(&retryPeriod, "leader-elect-retry-period", 2*time.Second, "how long replicas wait between attempts to acquire or renew the lease")
 	flag.Parse()
 }
 
 func main() {
-	var config *rest.Config
-	var err error
+	switch flag.Arg(0) {
+	case "bench":
+		runBench(flag.Args()[1:])
+		return
+	case "keygen":
+		runKeygen(flag.Args()[1:])
+		return
+	case "prove":
+		runProve(flag.Args()[1:])
+		return
+	case "send":
+		runSend(flag.Args()[1:])
+		return
+	case "wallet":
+		runWallet(flag.Args()[1:])
+		return
+	}
 
-	if kubeconfig == "" {
-		log.Printf("using in-cluster configuration")
-		config, err = rest.InClusterConfig()
-	} else {
-		log.Printf("using configuration from '%s'", kubeconfig)
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if rewardAddress != "" {
+		if err := wallet.ValidateAddress(rewardAddress); err != nil {
+			log.Fatalf("invalid -reward-address %q: %v", rewardAddress, err)
+		}
 	}
 
+	config := buildConfig()
+
+	err := v1alpha1.AddToScheme(scheme.Scheme)
 	if err != nil {
 		panic(err)
 	}
 
-	err = v1alpha1.AddToScheme(scheme.Scheme)
+	client, err := clientset.NewForConfig(config)
 	if err != nil {
 		panic(err)
 	}
 
-	client, err := clientset.NewForConfig(config)
+	kubeClient, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		panic(err)
 	}
 
+	// Proof of authority chains read the key the controller signs blocks with from a Secret.
+	secretData := func(namespace, name string) (map[string][]byte, error) {
+		secret, err := kubeClient.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return secret.Data, nil
+	}
+
+	// Record events, e.g. reorganizations of a blockchain, on the resources they concern.
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "kubechain"})
+
 	// Create the queue for block events.
 	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
 
@@ -79,13 +204,110 @@ func main() {
 	// to be processed.
 	informer := blockchain.NewInformer(defaultNamespace, client, queue)
 
-	// Construct our controller from the given queue and informers and a new blockcahin.
+	// Create the informer which has a cache of all the blockchains blocks are added to.
+	chainInformer := blockchain.NewBlockchainInformer(defaultNamespace, client)
+
+	// Create the informer which has a cache of all the transactions batched into blocks.
+	txInformer := blockchain.NewTransactionInformer(defaultNamespace, client)
+
+	// Without raft the controller runs standalone.
+	var replicator blockchain.Replicator
+	if raftEnabled {
+		config := replication.Config{
+			ID:               raftID,
+			BindAddress:      raftBind,
+			AdvertiseAddress: raftAdvertise,
+			DataDir:          raftDataDir,
+			PeerService:      raftPeerService,
+			BootstrapExpect:  raftBootstrapExpect,
+		}
+		if raftPeers != "" {
+			config.Peers = strings.Split(raftPeers, ",")
+		}
+		replicator = replication.New(config)
+	}
+
+	// Construct our controller from the given queue and informers.
 	controller := blockchain.NewController(
 		queue,
 		informer,
-		new(v1alpha1.Blockchain),
-		client)
+		chainInformer,
+		txInformer,
+		client,
+		recorder,
+		consensus.Config{MiningWorkers: miningWorkers, SecretData: secretData},
+		ledger.Config{RewardAddress: rewardAddress},
+		chain.NewOrphanPool(orphanPoolSize, orphanTTL),
+		blockchain.BatchConfig{MaxTransactions: blockMaxTransactions, Interval: blockInterval},
+		replicator)
+
+	// Serve queries, e.g. merkle proofs or balances, from the caches of the informers
+	// and the ledgers of the controller. The caches and the ledgers are only filled
+	// while the controller runs, standby replicas are not ready and answer nothing
+	// but their readiness.
+	if apiAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle(readinessPath, readiness(controller.Ready))
+		mux.Handle("/", whenReady(controller.Ready, api.NewServer(informer.GetIndexer(), chainInformer.GetIndexer(), controller.Chains())))
+		go func() {
+			log.Fatal(http.ListenAndServe(apiAddress, mux))
+		}()
+	}
+
+	// Validate the blocks submitted to the API server. Every replica serves the webhook
+	// but only those which are ready answer it, see whenReady.
+	if webhookAddress != "" {
+		startWebhook(kubeClient, client, controller.Chains(), controller.Ready)
+	}
 
-	controller.Run(threadCount, wait.NeverStop)
+	if !leaderElect {
+		controller.Run(threadCount, wait.NeverStop)
+		return
+	}
+	runLeaderElection(kubeClient, func(stopCh <-chan struct{}) {
+		controller.Run(threadCount, stopCh)
+	})
+}
+
+// The path the readiness of the replica is served on, along with the API.
+const readinessPath = "/readyz"
+
+// whenReady serves requests with handler while ready reports true and answers 503
+// otherwise, e.g. on standby replicas.
+func whenReady(ready func() bool, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ready() {
+			http.Error(w, "the controller is not running on this replica", http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// readiness answers the readiness probes of the replica, so the Services in front of
+// the replicas only route to those whose controller is running.
+func readiness(ready func() bool) http.Handler {
+	return whenReady(ready, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+}
 
+// buildConfig returns the configuration of the Kubernetes client, from -kubeconfig
+// or the in-cluster configuration.
+func buildConfig() *rest.Config {
+	var config *rest.Config
+	var err error
+
+	if kubeconfig == "" {
+		log.Printf("using in-cluster configuration")
+		config, err = rest.InClusterConfig()
+	} else {
+		log.Printf("using configuration from '%s'", kubeconfig)
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+
+	if err != nil {
+		panic(err)
+	}
+	return config
 }
@@ -0,0 +1,137 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	clientset "github.com/nimrodshn/kubechain/pkg/clientset/v1alpha1"
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// runProve prints the merkle proof that an entry, given by its index or its
+// transaction ID, is part of a block, e.g. `kubechain prove example-block 0`.
+// With -verify it verifies a proof read from a file instead, against the hash of a
+// block the user trusts, e.g. `kubechain prove -verify proof.json -hash 0000ab12...`.
+func runProve(args []string) {
+	flags := flag.NewFlagSet("prove", flag.ExitOnError)
+	namespace := flags.String("namespace", defaultNamespace, "namespace of the block")
+	verify := flags.String("verify", "", "verify the proof in the given file, - for stdin")
+	hash := flags.String("hash", "", "hex encoded hash of the block the proof must be for, required with -verify")
+	manifest := flags.String("blockchain", "", "manifest of the blockchain declaring the trusted signers, required to verify proof of authority blocks")
+	flags.Parse(args)
+
+	if *verify != "" {
+		verifyProof(*verify, *hash, *manifest)
+		return
+	}
+	if flags.NArg() != 2 {
+		log.Fatalf("usage: kubechain prove [-namespace namespace] <block> <entry>")
+	}
+	name, entry := flags.Arg(0), flags.Arg(1)
+
+	if err := v1alpha1.AddToScheme(scheme.Scheme); err != nil {
+		log.Fatal(err)
+	}
+	client, err := clientset.NewForConfig(buildConfig())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	block, err := client.Block(*namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		log.Fatalf("failed to get block %s/%s: %v", *namespace, name, err)
+	}
+	consensus := ""
+	blockchain, err := client.Blockchain(*namespace).Get(block.ChainName(), metav1.GetOptions{})
+	switch {
+	case err == nil:
+		consensus = blockchain.Spec.Consensus
+	case errors.IsNotFound(err) && block.ChainName() == v1alpha1.DefaultChainName:
+	default:
+		log.Fatalf("failed to get blockchain %s/%s: %v", *namespace, block.ChainName(), err)
+	}
+
+	index, err := block.TransactionIndex(entry)
+	if err != nil {
+		log.Fatal(err)
+	}
+	proof, err := v1alpha1.NewMerkleProof(block, consensus, index)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out, err := json.MarshalIndent(proof, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(out))
+}
+
+// verifyProof verifies the merkle proof in the given file against the block with the
+// given hex encoded hash, using the signers declared in the blockchain manifest, if any.
+func verifyProof(path, hash, manifest string) {
+	if hash == "" {
+		log.Fatalf("usage: kubechain prove -verify <proof> -hash <block hash> [-blockchain <manifest>]")
+	}
+	expected, err := hex.DecodeString(hash)
+	if err != nil {
+		log.Fatalf("invalid block hash %q: %v", hash, err)
+	}
+	var authority *v1alpha1.AuthorityPolicy
+	if manifest != "" {
+		file, err := os.Open(manifest)
+		if err != nil {
+			log.Fatalf("failed to read blockchain manifest: %v", err)
+		}
+		var blockchain v1alpha1.Blockchain
+		err = yaml.NewYAMLOrJSONDecoder(file, 4096).Decode(&blockchain)
+		file.Close()
+		if err != nil {
+			log.Fatalf("failed to decode blockchain manifest: %v", err)
+		}
+		authority = blockchain.Spec.Authority
+	}
+
+	var data []byte
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		log.Fatalf("failed to read proof: %v", err)
+	}
+
+	var proof v1alpha1.MerkleProof
+	if err := json.Unmarshal(data, &proof); err != nil {
+		log.Fatalf("failed to decode proof: %v", err)
+	}
+	if err := proof.Verify(expected, authority); err != nil {
+		log.Fatalf("invalid proof: %v", err)
+	}
+	fmt.Printf("transaction %x is entry %d of block %x\n", proof.Transaction.Hash(), proof.Index, proof.Header.Hash)
+}
@@ -0,0 +1,189 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	clientset "github.com/nimrodshn/kubechain/pkg/clientset/v1alpha1"
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+	"github.com/nimrodshn/kubechain/pkg/wallet"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// runSend submits a Transaction transferring value from a wallet to an address of a
+// UTXO or account chain, e.g. `kubechain send -wallet alice -to 1Bob... -amount 3`. The
+// outputs it spends, or the nonce of the account it is sent from, are looked up through
+// the API of the controller and the transaction is signed with the wallet, which is read
+// from its Secret.
+func runSend(args []string) {
+	flags := flag.NewFlagSet("send", flag.ExitOnError)
+	namespace := flags.String("namespace", defaultNamespace, "namespace of the blockchain and the wallet")
+	chainName := flags.String("chain", v1alpha1.DefaultChainName, "name of the blockchain")
+	apiURL := flags.String("api", "http://localhost:8080", "URL of the kubechain API")
+	walletName := flags.String("wallet", "", "name of the Secret holding the wallet the value is sent from")
+	to := flags.String("to", "", "address the value is sent to")
+	amount := flags.Int64("amount", 0, "value to send")
+	nonce := flags.Int64("nonce", -1, "nonce of the transfer on account chains, the nonce of the account at the tip of the chain when negative")
+	flags.Parse(args)
+
+	if *walletName == "" || *to == "" || *amount <= 0 {
+		log.Fatalf("usage: kubechain send [-namespace namespace] [-chain name] [-api url] -wallet name -to address -amount value")
+	}
+	if err := wallet.ValidateAddress(*to); err != nil {
+		log.Fatalf("invalid address %q: %v", *to, err)
+	}
+
+	if err := v1alpha1.AddToScheme(scheme.Scheme); err != nil {
+		log.Fatal(err)
+	}
+	config := buildConfig()
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err := clientset.NewForConfig(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	secret, err := kubeClient.CoreV1().Secrets(*namespace).Get(*walletName, metav1.GetOptions{})
+	if err != nil {
+		log.Fatalf("failed to get wallet %s/%s: %v", *namespace, *walletName, err)
+	}
+	w, err := wallet.FromSecretData(secret.Data)
+	if err != nil {
+		log.Fatalf("invalid wallet %s/%s: %v", *namespace, *walletName, err)
+	}
+	from := w.Address()
+
+	blockchain, err := client.Blockchain(*namespace).Get(*chainName, metav1.GetOptions{})
+	if err != nil {
+		log.Fatalf("failed to get blockchain %s/%s: %v", *namespace, *chainName, err)
+	}
+	var tx *v1alpha1.Transaction
+	switch blockchain.Spec.Ledger {
+	case v1alpha1.LedgerUTXO:
+		outputs, err := fetchUnspentOutputs(*apiURL, *namespace, *chainName, from)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tx, err = newTransfer(outputs, from, *to, *amount)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case v1alpha1.LedgerAccount:
+		transfer := &v1alpha1.Transfer{From: from, To: *to, Amount: *amount, Nonce: uint64(*nonce)}
+		if *nonce < 0 {
+			account, err := fetchAccount(*apiURL, *namespace, *chainName, from)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if account.Balance < *amount {
+				log.Fatalf("%s owns %d, not enough to send %d", from, account.Balance, *amount)
+			}
+			transfer.Nonce = account.Nonce
+		}
+		tx = &v1alpha1.Transaction{Transfer: transfer}
+	default:
+		log.Fatalf("blockchain %s/%s does not track value", *namespace, *chainName)
+	}
+	if err := w.Sign(tx); err != nil {
+		log.Fatalf("failed to sign transaction: %v", err)
+	}
+
+	created, err := client.Transaction(*namespace).Create(&v1alpha1.TransactionRequest{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "send-"},
+		Spec: v1alpha1.TransactionRequestSpec{
+			Chain:    *chainName,
+			Inputs:   tx.Inputs,
+			Outputs:  tx.Outputs,
+			Transfer: tx.Transfer,
+		},
+	})
+	if err != nil {
+		log.Fatalf("failed to create transaction: %v", err)
+	}
+	fmt.Printf("transaction %s/%s sends %d from %s to %s\n", created.Namespace, created.Name, *amount, from, *to)
+}
+
+// newTransfer returns a transaction spending enough of outputs to pay amount to the
+// address to, the remainder is paid back to the address from.
+func newTransfer(outputs []v1alpha1.UnspentOutput, from, to string, amount int64) (*v1alpha1.Transaction, error) {
+	tx := &v1alpha1.Transaction{}
+	var total int64
+	for _, output := range outputs {
+		if total >= amount {
+			break
+		}
+		tx.Inputs = append(tx.Inputs, v1alpha1.TxInput{TxID: output.TxID, Vout: output.Vout})
+		total += output.Value
+	}
+	if total < amount {
+		return nil, fmt.Errorf("%s owns %d, not enough to send %d", from, total, amount)
+	}
+	tx.Outputs = append(tx.Outputs, v1alpha1.TxOutput{Value: amount, Address: to})
+	if total > amount {
+		tx.Outputs = append(tx.Outputs, v1alpha1.TxOutput{Value: total - amount, Address: from})
+	}
+	return tx, nil
+}
+
+// fetchUnspentOutputs returns the outputs paid to address which are unspent on the blockchain.
+func fetchUnspentOutputs(apiURL, namespace, chainName, address string) ([]v1alpha1.UnspentOutput, error) {
+	var outputs []v1alpha1.UnspentOutput
+	if err := getLedger(apiURL, namespace, chainName, "utxos", address, &outputs); err != nil {
+		return nil, fmt.Errorf("failed to query unspent outputs: %v", err)
+	}
+	return outputs, nil
+}
+
+// fetchAccount returns the account with the given address at the tip of the blockchain.
+func fetchAccount(apiURL, namespace, chainName, address string) (*v1alpha1.AccountStatus, error) {
+	account := &v1alpha1.AccountStatus{}
+	if err := getLedger(apiURL, namespace, chainName, "accounts", address, account); err != nil {
+		return nil, fmt.Errorf("failed to query account: %v", err)
+	}
+	return account, nil
+}
+
+// getLedger decodes the response of the API about address from the ledger of a blockchain into v.
+func getLedger(apiURL, namespace, chainName, resource, address string, v interface{}) error {
+	resp, err := http.Get(fmt.Sprintf("%s/namespaces/%s/blockchains/%s/%s/%s",
+		apiURL, url.PathEscape(namespace), url.PathEscape(chainName), resource, url.PathEscape(address)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/nimrodshn/kubechain/pkg/wallet"
+
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// runWallet generates a wallet and prints the Secret holding it along with its
+// address, e.g. `kubechain wallet -name alice | kubectl create -f -`.
+func runWallet(args []string) {
+	flags := flag.NewFlagSet("wallet", flag.ExitOnError)
+	name := flags.String("name", "", "name of the Secret holding the wallet")
+	flags.Parse(args)
+
+	if *name == "" {
+		log.Fatalf("the name of the wallet is required")
+	}
+
+	w, err := wallet.New()
+	if err != nil {
+		log.Fatalf("failed to generate wallet: %v", err)
+	}
+	data, err := w.SecretData()
+	if err != nil {
+		log.Fatalf("failed to encode wallet: %v", err)
+	}
+
+	fmt.Printf("# Address: %s\n", w.Address())
+	fmt.Printf("apiVersion: v1\n")
+	fmt.Printf("kind: Secret\n")
+	fmt.Printf("metadata:\n")
+	fmt.Printf("  name: %q\n", *name)
+	fmt.Printf("type: Opaque\n")
+	fmt.Printf("data:\n")
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("  %s: %q\n", key, base64.StdEncoding.EncodeToString(data[key]))
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/nimrodshn/kubechain/pkg/chain"
+	clientset "github.com/nimrodshn/kubechain/pkg/clientset/v1alpha1"
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+
+	"github.com/nimrodshn/kubechain/pkg/admission"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"crypto/tls"
+	"log"
+	"net/http"
+)
+
+// The path the API server posts the AdmissionReviews of blocks to.
+const webhookPath = "/validate-blocks"
+
+// startWebhook serves the validating admission webhook of blocks on -webhook-address.
+// The serving certificate is read from the -webhook-cert-secret Secret, or generated
+// for the -webhook-service Service, in which case the CA bundle of the
+// -webhook-configuration ValidatingWebhookConfiguration is updated to trust it.
+// The difficulty required from blocks is looked up in chains, AdmissionReviews are
+// only answered while ready reports true.
+func startWebhook(kubeClient kubernetes.Interface, client *clientset.KubechainV1Alpha1Client, chains *chain.Manager, ready func() bool) {
+	var cert tls.Certificate
+	if webhookCertSecret != "" {
+		secret, err := kubeClient.CoreV1().Secrets(defaultNamespace).Get(webhookCertSecret, metav1.GetOptions{})
+		if err != nil {
+			log.Fatalf("failed to get webhook certificate secret %s/%s: %v", defaultNamespace, webhookCertSecret, err)
+		}
+		cert, err = admission.CertificateFromSecret(secret.Data)
+		if err != nil {
+			log.Fatalf("invalid webhook certificate secret %s/%s: %v", defaultNamespace, webhookCertSecret, err)
+		}
+	} else {
+		dnsNames := []string{
+			webhookService,
+			webhookService + "." + defaultNamespace,
+			webhookService + "." + defaultNamespace + ".svc",
+		}
+		var caBundle []byte
+		var err error
+		cert, caBundle, err = admission.GenerateCertificate(dnsNames)
+		if err != nil {
+			log.Fatalf("failed to generate the webhook certificate: %v", err)
+		}
+		if webhookConfiguration != "" {
+			if err := updateCABundle(kubeClient, caBundle); err != nil {
+				log.Fatalf("failed to update the CA bundle of webhook configuration %s: %v", webhookConfiguration, err)
+			}
+		}
+	}
+
+	blockchains := func(namespace, name string) (*v1alpha1.Blockchain, error) {
+		blockchain, err := client.Blockchain(namespace).Get(name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return blockchain, err
+	}
+	recordDeleter := func(namespace, name, username string) error {
+		blocks := client.Block(namespace)
+		return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			block, err := blocks.Get(name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if block.Annotations == nil {
+				block.Annotations = make(map[string]string)
+			}
+			block.Annotations[v1alpha1.DeletedByAnnotation] = username
+			_, err = blocks.Update(block)
+			return err
+		})
+	}
+	mux := http.NewServeMux()
+	mux.Handle(webhookPath, whenReady(ready, admission.NewWebhook(webhookControllerUser, blockchains, chains.RequiredDifficulty, recordDeleter)))
+	mux.Handle(readinessPath, readiness(ready))
+	go func() {
+		log.Fatal(admission.Serve(webhookAddress, cert, mux))
+	}()
+}
+
+// updateCABundle sets the CA bundle of every webhook of the -webhook-configuration
+// ValidatingWebhookConfiguration to the self-generated certificate.
+func updateCABundle(kubeClient kubernetes.Interface, caBundle []byte) error {
+	webhooks := kubeClient.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations()
+	configuration, err := webhooks.Get(webhookConfiguration, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		log.Printf("webhook configuration %s does not exist, it must trust the generated certificate", webhookConfiguration)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i := range configuration.Webhooks {
+		configuration.Webhooks[i].ClientConfig.CABundle = caBundle
+	}
+	_, err = webhooks.Update(configuration)
+	return err
+}
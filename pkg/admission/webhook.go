@@ -0,0 +1,352 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package admission validates the blocks submitted to the API server through a
+// validating admission webhook, enforcing the rules of the blockchains the CRD
+// schema cannot express before the controller sees the blocks.
+package admission
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+
+	"github.com/golang/glog"
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The maximum size of an AdmissionReview, blocks are limited to about 1.5MiB by etcd.
+const maxReviewSize = 4 << 20
+
+// Blockchains returns the named blockchain, or nil when it does not exist yet.
+type Blockchains func(namespace, name string) (*v1alpha1.Blockchain, error)
+
+// Difficulties returns the difficulty the rules of the blockchain with the given key
+// require from a block on top of parent, e.g. chain.Manager.RequiredDifficulty.
+type Difficulties func(key string, parent []byte) (int64, error)
+
+// RecordDeleter records the user deleting the named block in its
+// v1alpha1.DeletedByAnnotation, for the tombstone of the block.
+type RecordDeleter func(namespace, name, username string) error
+
+// Webhook serves the AdmissionReviews of the API server for blocks, it rejects
+//
+//   - blocks created with a partial seal, i.e. setting nonce or prev_block_hash without
+//     a hash. The seal fields are set by the controller when it mines a block.
+//   - blocks created sealed, e.g. imported from another cluster, whose seal does not
+//     match their header. The seal of proof of work blocks is checked with
+//     ProofOfWork.Validate, which holds them to the difficulty bounds. On chains which
+//     retarget they must also record the difficulty required at their height, which
+//     depends on their branch: for blocks whose parent is not known yet, e.g. orphans,
+//     it is left to the controller, which verifies them when they are connected.
+//     The signer of proof of authority blocks is left to the controller as it depends
+//     on the height of the block.
+//   - changes to the spec of blocks which are sealed, i.e. mined, stale or orphan.
+//   - changes to the spec of blocks being mined, except by the controller preparing
+//     and sealing them.
+//   - data exceeding v1alpha1.MaxDataSize, in the block or in one of its transactions.
+//   - deletions of sealed blocks, i.e. mined, stale or orphan, unless their blockchain
+//     allows pruning. The user deleting a block is then recorded for its tombstone.
+//     It is recorded before the deletion is admitted, a deletion rejected afterwards,
+//     e.g. by another webhook, leaves it on the block until the next deletion reviewed
+//     here replaces it.
+type Webhook struct {
+	// controller is the user the controller authenticates as, e.g. its service account.
+	controller    string
+	blockchains   Blockchains
+	difficulties  Difficulties
+	recordDeleter RecordDeleter
+}
+
+// NewWebhook is a constructor for the webhook, controller is the user the controller
+// authenticates as, blockchains looks up the blockchain of the reviewed blocks,
+// difficulties the difficulty required from them and recordDeleter records who
+// deletes sealed blocks.
+func NewWebhook(controller string, blockchains Blockchains, difficulties Difficulties, recordDeleter RecordDeleter) *Webhook {
+	return &Webhook{
+		controller:    controller,
+		blockchains:   blockchains,
+		difficulties:  difficulties,
+		recordDeleter: recordDeleter,
+	}
+}
+
+// ServeHTTP answers an AdmissionReview posted by the API server.
+func (h *Webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxReviewSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	review := admissionv1beta1.AdmissionReview{}
+	if err := json.Unmarshal(body, &review); err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode the admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+	if review.Request == nil {
+		http.Error(w, "the admission review carries no request", http.StatusBadRequest)
+		return
+	}
+
+	response := h.Review(review.Request)
+	response.UID = review.Request.UID
+	review.Request = nil
+	review.Response = response
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&review); err != nil {
+		glog.Errorf("Failed to write admission response: %v", err)
+	}
+}
+
+// Review admits or rejects the block of request.
+func (h *Webhook) Review(request *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	if err := h.validate(request); err != nil {
+		glog.Infof("Rejecting %s of block %s/%s: %v", request.Operation, request.Namespace, request.Name, err)
+		return &admissionv1beta1.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Reason:  metav1.StatusReasonInvalid,
+				Code:    http.StatusUnprocessableEntity,
+				Message: err.Error(),
+			},
+		}
+	}
+	return &admissionv1beta1.AdmissionResponse{Allowed: true}
+}
+
+func (h *Webhook) validate(request *admissionv1beta1.AdmissionRequest) error {
+	// Only the blocks themselves are reviewed, the controller owns their status.
+	if request.Kind.Kind != "Block" || request.SubResource != "" {
+		return nil
+	}
+	if request.Operation == admissionv1beta1.Delete {
+		return h.validateDelete(request)
+	}
+	block := &v1alpha1.Block{}
+	if err := json.Unmarshal(request.Object.Raw, block); err != nil {
+		return fmt.Errorf("failed to decode the block: %v", err)
+	}
+	if err := validateData(block); err != nil {
+		return err
+	}
+
+	switch request.Operation {
+	case admissionv1beta1.Create:
+		return h.validateSeal(block)
+	case admissionv1beta1.Update:
+		old := &v1alpha1.Block{}
+		if err := json.Unmarshal(request.OldObject.Raw, old); err != nil {
+			return fmt.Errorf("failed to decode the current block: %v", err)
+		}
+		return h.validateUpdate(request.UserInfo.Username, old, block)
+	}
+	return nil
+}
+
+// validateUpdate rejects changes to the spec of sealed blocks. Blocks being mined
+// may only be prepared and sealed by the controller, username is the user updating them.
+func (h *Webhook) validateUpdate(username string, old, block *v1alpha1.Block) error {
+	if reflect.DeepEqual(old.Spec, block.Spec) {
+		return nil
+	}
+	switch old.Status.Phase {
+	case v1alpha1.BlockMined, v1alpha1.BlockStale, v1alpha1.BlockOrphan:
+		return fmt.Errorf("the block is %s, its spec cannot be changed", old.Status.Phase)
+	case v1alpha1.BlockMining:
+		if username != h.controller {
+			return fmt.Errorf("the block is being mined, only the controller may change its spec")
+		}
+		if !preparedOnly(old, block) {
+			return fmt.Errorf("the block is being mined, only its header, its seal and the transactions the ledger accepts may change")
+		}
+		return nil
+	}
+	if sealChanged(old, block) {
+		return h.validateSeal(block)
+	}
+	return nil
+}
+
+// validateDelete rejects the deletion of sealed blocks of blockchains which do not
+// allow pruning, and records who deletes the blocks of those which do.
+func (h *Webhook) validateDelete(request *admissionv1beta1.AdmissionRequest) error {
+	// API servers before 1.15 do not send the deleted block, the finalizer of the
+	// controller still keeps it.
+	if len(request.OldObject.Raw) == 0 {
+		return nil
+	}
+	block := &v1alpha1.Block{}
+	if err := json.Unmarshal(request.OldObject.Raw, block); err != nil {
+		return fmt.Errorf("failed to decode the block: %v", err)
+	}
+	switch block.Status.Phase {
+	case v1alpha1.BlockMined, v1alpha1.BlockStale, v1alpha1.BlockOrphan:
+	default:
+		return nil
+	}
+
+	chain, err := h.blockchains(block.Namespace, block.ChainName())
+	if err != nil {
+		return fmt.Errorf("failed to get blockchain %s: %v", block.ChainName(), err)
+	}
+	if chain == nil || !chain.AllowsPruning() {
+		return fmt.Errorf("the block is %s and blockchain %s does not allow pruning", block.Status.Phase, block.ChainName())
+	}
+	if request.DryRun != nil && *request.DryRun {
+		return nil
+	}
+	if err := h.recordDeleter(block.Namespace, block.Name, request.UserInfo.Username); err != nil {
+		return fmt.Errorf("failed to record the deleter of the block: %v", err)
+	}
+	return nil
+}
+
+// validateSeal checks the seal fields of a block submitted by a user are either all
+// unset, in which case the controller seals it, or form a valid seal.
+func (h *Webhook) validateSeal(block *v1alpha1.Block) error {
+	if len(block.Spec.Hash) == 0 {
+		if block.Spec.Nonce != 0 || len(block.Spec.PrevBlockHash) != 0 || block.Spec.Signer != "" || len(block.Spec.Signature) != 0 {
+			return fmt.Errorf("nonce, prev_block_hash and the signature are set by the controller, blocks submitted sealed must also carry their hash")
+		}
+		return nil
+	}
+
+	if err := block.ValidateMerkleRoot(); err != nil {
+		return err
+	}
+	chain, err := h.blockchains(block.Namespace, block.ChainName())
+	if err != nil {
+		return fmt.Errorf("failed to get blockchain %s: %v", block.ChainName(), err)
+	}
+	if chain == nil {
+		// The blockchain is created with the default spec on first use.
+		chain = &v1alpha1.Blockchain{}
+		chain.Namespace, chain.Name = block.Namespace, block.ChainName()
+	}
+	consensus := v1alpha1.ConsensusProofOfWork
+	if chain.Spec.Consensus != "" {
+		consensus = chain.Spec.Consensus
+	}
+
+	switch consensus {
+	case v1alpha1.ConsensusProofOfWork:
+		if chain.Spec.Retarget != nil {
+			// Orphans are admitted within the bounds only, the controller verifies
+			// them against their branch once their parent is connected.
+			required, ok := h.requiredDifficulty(chain, block.Spec.PrevBlockHash)
+			if ok && block.Difficulty() != required {
+				return fmt.Errorf("the block records difficulty %d but %d is required at its height", block.Difficulty(), required)
+			}
+		}
+		pow := v1alpha1.NewProofOfWork(block)
+		if !pow.Validate() || !bytes.Equal(pow.Hash(), block.Spec.Hash) {
+			return fmt.Errorf("the hash of the block is not a valid proof of work of its header")
+		}
+	case v1alpha1.ConsensusProofOfAuthority:
+		if block.Spec.Signer == "" || len(block.Spec.Signature) == 0 || !bytes.Equal(block.AuthorityHash(), block.Spec.Hash) {
+			return fmt.Errorf("the block is not signed or its hash does not match its header")
+		}
+	}
+	return nil
+}
+
+// requiredDifficulty returns the difficulty the rules of chain, which retargets its
+// difficulty, require from a block on top of parent. The controller knows every branch
+// of the blockchains it tracks, otherwise, e.g. on standbys, it is only known for
+// genesis blocks and blocks on top of the tip of the blockchain.
+func (h *Webhook) requiredDifficulty(chain *v1alpha1.Blockchain, parent []byte) (int64, bool) {
+	if h.difficulties != nil {
+		if difficulty, err := h.difficulties(chain.Namespace+"/"+chain.Name, parent); err == nil {
+			return difficulty, true
+		}
+	}
+	switch {
+	case len(parent) == 0:
+		return chain.RequiredDifficulty(0), true
+	case bytes.Equal(parent, chain.Status.TipHash) && chain.Status.Difficulty != 0:
+		return chain.Status.Difficulty, true
+	}
+	return 0, false
+}
+
+// sealChanged reports whether the seal fields of block differ from those of old.
+func sealChanged(old, block *v1alpha1.Block) bool {
+	return !bytes.Equal(old.Spec.Hash, block.Spec.Hash) || old.Spec.Nonce != block.Spec.Nonce ||
+		!bytes.Equal(old.Spec.PrevBlockHash, block.Spec.PrevBlockHash) ||
+		old.Spec.Signer != block.Spec.Signer || !bytes.Equal(old.Spec.Signature, block.Spec.Signature)
+}
+
+// preparedOnly reports whether block only differs from old by what the controller
+// changes while mining it: the header and the seal, and the transactions, which may
+// gain the coinbase in front and lose the transactions rejected by the ledger.
+func preparedOnly(old, block *v1alpha1.Block) bool {
+	if old.Spec.Chain != block.Spec.Chain || old.Spec.Data != block.Spec.Data {
+		return false
+	}
+	transactions := block.Spec.Transactions
+	if len(transactions) > 0 && isCoinbase(&transactions[0]) {
+		transactions = transactions[1:]
+	}
+	// The remaining transactions must be the submitted ones, in order, but for their ID.
+	entries := old.Transactions()
+	next := 0
+	for _, tx := range transactions {
+		tx.ID = nil
+		for next < len(entries) && !reflect.DeepEqual(withoutID(entries[next]), tx) {
+			next++
+		}
+		if next == len(entries) {
+			return false
+		}
+		next++
+	}
+	return true
+}
+
+// isCoinbase reports whether tx has the shape of a coinbase, creating outputs from nothing.
+func isCoinbase(tx *v1alpha1.Transaction) bool {
+	return len(tx.Inputs) == 0 && len(tx.Outputs) > 0 && tx.Transfer == nil
+}
+
+func withoutID(tx v1alpha1.Transaction) v1alpha1.Transaction {
+	tx.ID = nil
+	return tx
+}
+
+// validateData checks the free-form data of the block and of its transactions does
+// not exceed v1alpha1.MaxDataSize.
+func validateData(block *v1alpha1.Block) error {
+	if len(block.Spec.Data) > v1alpha1.MaxDataSize {
+		return fmt.Errorf("the data of the block exceeds %d bytes", v1alpha1.MaxDataSize)
+	}
+	for i, tx := range block.Spec.Transactions {
+		if len(tx.Data) > v1alpha1.MaxDataSize {
+			return fmt.Errorf("the data of transaction %d exceeds %d bytes", i, v1alpha1.MaxDataSize)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admission
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// sealedBlock returns a block on top of parent mined at the given difficulty.
+func sealedBlock(t *testing.T, parent string, difficulty int64) *v1alpha1.Block {
+	block := &v1alpha1.Block{Spec: v1alpha1.BlockSpec{Chain: "retarget", PrevBlockHash: []byte(parent), Difficulty: difficulty}}
+	pow := v1alpha1.NewProofOfWork(block)
+	nonce, hash, err := pow.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.Spec.Nonce, block.Spec.Hash = nonce, hash
+	return block
+}
+
+func TestValidateSealRetarget(t *testing.T) {
+	chain := &v1alpha1.Blockchain{Spec: v1alpha1.BlockchainSpec{
+		Difficulty: v1alpha1.MinDifficulty,
+		Retarget:   &v1alpha1.RetargetPolicy{Interval: 4, TargetBlockTime: metav1.Duration{Duration: time.Minute}},
+	}}
+	chain.Status.TipHash = []byte("tip")
+	chain.Status.Difficulty = v1alpha1.MinDifficulty + 1
+	h := NewWebhook("controller", func(namespace, name string) (*v1alpha1.Blockchain, error) {
+		return chain, nil
+	}, nil, nil)
+
+	if err := h.validateSeal(sealedBlock(t, "tip", v1alpha1.MinDifficulty+1)); err != nil {
+		t.Fatalf("expected a block at the required difficulty to be admitted, got %v", err)
+	}
+	if err := h.validateSeal(sealedBlock(t, "tip", v1alpha1.MinDifficulty)); err == nil {
+		t.Fatal("expected a block on top of the tip at another difficulty to be rejected")
+	}
+	// The difficulty required from orphans is only known once their parent is connected.
+	if err := h.validateSeal(sealedBlock(t, "unknown", v1alpha1.MinDifficulty)); err != nil {
+		t.Fatalf("expected an orphan within the bounds to be admitted, got %v", err)
+	}
+	orphan := sealedBlock(t, "unknown", v1alpha1.MinDifficulty)
+	orphan.Spec.Difficulty = v1alpha1.MinDifficulty - 1
+	if err := h.validateSeal(orphan); err == nil {
+		t.Fatal("expected an orphan out of the bounds to be rejected")
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package api serves read-only queries about the blocks and blockchains known to
+// the controller over HTTP, e.g. merkle proofs of block entries or balances.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Ledgers answers queries about the ledgers of the blockchains, keyed by namespace/name,
+// see chain.Manager.
+type Ledgers interface {
+	Balance(chainKey, address string) (int64, error)
+	UnspentOutputs(chainKey, address string) ([]v1alpha1.UnspentOutput, error)
+	Account(chainKey, address string) (v1alpha1.AccountStatus, error)
+}
+
+// Balance is the value owned by an address on a blockchain.
+type Balance struct {
+	Address string `json:"address"`
+	Balance int64  `json:"balance"`
+}
+
+// Server serves the API from the caches of the controller informers and the
+// ledgers of the blockchains.
+type Server struct {
+	blocks      cache.Indexer
+	blockchains cache.Indexer
+	ledgers     Ledgers
+}
+
+// NewServer is a constructor for the API server.
+func NewServer(blocks, blockchains cache.Indexer, ledgers Ledgers) *Server {
+	return &Server{
+		blocks:      blocks,
+		blockchains: blockchains,
+		ledgers:     ledgers,
+	}
+}
+
+// ServeHTTP serves
+//
+//	GET /namespaces/{namespace}/blocks/{name}/proof?entry={index or transaction ID}
+//	GET /namespaces/{namespace}/blockchains/{name}/balances/{address}
+//	GET /namespaces/{namespace}/blockchains/{name}/utxos/{address}
+//	GET /namespaces/{namespace}/blockchains/{name}/accounts/{address}
+//
+// which return the merkle proof that an entry is part of a block, the value owned
+// by an address, the unspent outputs paid to an address on UTXO chains and the
+// account of an address on account chains respectively.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 5 && parts[0] == "namespaces" && parts[2] == "blocks" && parts[4] == "proof" {
+		s.serveProof(w, parts[1], parts[3], r.URL.Query().Get("entry"))
+		return
+	}
+	if len(parts) == 6 && parts[0] == "namespaces" && parts[2] == "blockchains" {
+		chainKey := parts[1] + "/" + parts[3]
+		switch parts[4] {
+		case "balances":
+			s.serveBalance(w, chainKey, parts[5])
+			return
+		case "utxos":
+			s.serveUnspentOutputs(w, chainKey, parts[5])
+			return
+		case "accounts":
+			s.serveAccount(w, chainKey, parts[5])
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func (s *Server) serveBalance(w http.ResponseWriter, chainKey, address string) {
+	balance, err := s.ledgers.Balance(chainKey, address)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, Balance{Address: address, Balance: balance})
+}
+
+func (s *Server) serveUnspentOutputs(w http.ResponseWriter, chainKey, address string) {
+	outputs, err := s.ledgers.UnspentOutputs(chainKey, address)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if outputs == nil {
+		outputs = []v1alpha1.UnspentOutput{}
+	}
+	writeJSON(w, outputs)
+}
+
+func (s *Server) serveAccount(w http.ResponseWriter, chainKey, address string) {
+	account, err := s.ledgers.Account(chainKey, address)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, account)
+}
+
+func (s *Server) serveProof(w http.ResponseWriter, namespace, name, entry string) {
+	block, err := s.block(namespace, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	consensus, err := s.consensus(namespace, block.ChainName())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	index, err := block.TransactionIndex(entry)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	proof, err := v1alpha1.NewMerkleProof(block, consensus, index)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeJSON(w, proof)
+}
+
+func (s *Server) block(namespace, name string) (*v1alpha1.Block, error) {
+	item, exists, err := s.blocks.GetByKey(namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("block %s/%s does not exist", namespace, name)
+	}
+	block, ok := item.(*v1alpha1.Block)
+	if !ok {
+		return nil, fmt.Errorf("expected a resource of type block instead got %T", item)
+	}
+	return block, nil
+}
+
+// consensus returns the consensus of the named blockchain.
+func (s *Server) consensus(namespace, name string) (string, error) {
+	item, exists, err := s.blockchains.GetByKey(namespace + "/" + name)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("blockchain %s/%s does not exist", namespace, name)
+	}
+	blockchain, ok := item.(*v1alpha1.Blockchain)
+	if !ok {
+		return "", fmt.Errorf("expected a resource of type blockchain instead got %T", item)
+	}
+	return blockchain.Spec.Consensus, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		glog.Errorf("Failed to write API response: %v", err)
+	}
+}
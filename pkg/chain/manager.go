@@ -0,0 +1,606 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package chain manages the in-memory state of the blockchains tracked by the controller.
+package chain
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/nimrodshn/kubechain/pkg/consensus"
+	"github.com/nimrodshn/kubechain/pkg/ledger"
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Manager serializes appends to the in-memory blockchains while still allowing
+// blocks to be sealed in parallel: workers prepare a block on the tip, seal it
+// without holding any lock and only take the lock of their blockchain to append.
+// A worker which sealed on a tip that moved in the meantime gets
+// v1alpha1.ErrTipMoved and must seal again.
+type Manager struct {
+	lock   sync.RWMutex
+	chains map[string]*managedChain
+}
+
+// managedChain guards a single blockchain, so appends to different chains do not contend.
+type managedChain struct {
+	lock       sync.RWMutex
+	blockchain *v1alpha1.Blockchain
+	engine     consensus.Consensus
+	// tree holds every block connected to the blockchain, the Chain of the
+	// blockchain is its main chain.
+	tree *v1alpha1.BlockTree
+	// genesis is the empty ledger of the blockchain and state the ledger at the tip
+	// of its main chain, both are nil when the blockchain has no ledger.
+	genesis ledger.Ledger
+	state   ledger.Ledger
+}
+
+// NewManager is a constructor for the chain manager.
+func NewManager() *Manager {
+	return &Manager{
+		chains: make(map[string]*managedChain),
+	}
+}
+
+// Track starts managing blockchain, sealed and verified by engine, under key,
+// usually namespace/name. genesis is the empty ledger of the blockchain, nil if it
+// has none. It returns false if a blockchain is already managed under key, in which
+// case it is kept.
+func (m *Manager) Track(key string, blockchain *v1alpha1.Blockchain, engine consensus.Consensus, genesis ledger.Ledger) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.chains[key]; ok {
+		return false
+	}
+	glog.Infof("Tracking blockchain %s", key)
+	tree := v1alpha1.NewBlockTree(engine.Weight)
+	for _, block := range blockchain.Chain {
+		tree.Add(block)
+	}
+	managed := &managedChain{blockchain: blockchain, engine: engine, tree: tree, genesis: genesis}
+	if genesis != nil {
+		state, err := replay(genesis, blockchain.Chain)
+		if err != nil {
+			glog.Errorf("Failed to replay the ledger of blockchain %s: %v", key, err)
+			state = genesis.Clone()
+		}
+		managed.state = state
+	}
+	m.chains[key] = managed
+	return true
+}
+
+// IsTracked reports whether a blockchain is managed under key.
+func (m *Manager) IsTracked(key string) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	_, ok := m.chains[key]
+	return ok
+}
+
+// Blockchain returns a copy of the blockchain managed under key, without its blocks.
+func (m *Manager) Blockchain(key string) (*v1alpha1.Blockchain, error) {
+	managed, err := m.get(key)
+	if err != nil {
+		return nil, err
+	}
+	managed.lock.RLock()
+	defer managed.lock.RUnlock()
+
+	blockchain := &v1alpha1.Blockchain{
+		TypeMeta:   managed.blockchain.TypeMeta,
+		ObjectMeta: managed.blockchain.ObjectMeta,
+		Spec:       managed.blockchain.Spec,
+	}
+	return blockchain.DeepCopy(), nil
+}
+
+// Forget stops managing the blockchain under key.
+func (m *Manager) Forget(key string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.chains, key)
+}
+
+func (m *Manager) get(key string) (*managedChain, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	managed, ok := m.chains[key]
+	if !ok {
+		return nil, fmt.Errorf("blockchain %s is not tracked", key)
+	}
+	return managed, nil
+}
+
+// Tip returns the hash of the last block of the blockchain and the height
+// the next block will be added at.
+func (m *Manager) Tip(key string) ([]byte, int64, error) {
+	managed, err := m.get(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	managed.lock.RLock()
+	defer managed.lock.RUnlock()
+
+	hash, height := managed.blockchain.Tip()
+	return hash, height, nil
+}
+
+// Prepare fixes the link to the current tip, the timestamp, the merkle root and
+// the consensus fields into the header of block, which must be done before sealing it since
+// the seal commits to the header. On ledger chains the transactions which cannot be
+// applied on the tip are dropped and the reward is claimed. It returns the height the
+// block is prepared for.
+func (m *Manager) Prepare(key string, block *v1alpha1.Block) (int64, error) {
+	managed, err := m.get(key)
+	if err != nil {
+		return 0, err
+	}
+	managed.lock.RLock()
+	defer managed.lock.RUnlock()
+
+	tip, height := managed.blockchain.Tip()
+	block.Spec.PrevBlockHash = tip
+	block.Spec.Timestamp = time.Now().Unix()
+	if managed.state != nil {
+		managed.state.Prepare(block, height)
+	}
+	block.SetMerkleRoot()
+	if err := managed.engine.Prepare(managed.blockchain, block); err != nil {
+		return 0, err
+	}
+	return height, nil
+}
+
+// Seal seals a prepared block with the consensus of the blockchain. No lock is
+// held while sealing so blocks of the same chain can be sealed in parallel.
+func (m *Manager) Seal(ctx context.Context, key string, block *v1alpha1.Block) error {
+	managed, err := m.get(key)
+	if err != nil {
+		return err
+	}
+	managed.lock.RLock()
+	engine := managed.engine
+	managed.lock.RUnlock()
+
+	return engine.Seal(ctx, block)
+}
+
+// UpdateSpec replaces the spec and the consensus engine of the blockchain,
+// e.g. when its difficulty is changed.
+func (m *Manager) UpdateSpec(key string, spec v1alpha1.BlockchainSpec, engine consensus.Consensus) error {
+	managed, err := m.get(key)
+	if err != nil {
+		return err
+	}
+	managed.lock.Lock()
+	defer managed.lock.Unlock()
+
+	managed.blockchain.Spec = spec
+	managed.engine = engine
+	return nil
+}
+
+// UpdateEngine replaces the consensus engine of the blockchain but keeps its spec,
+// e.g. when the key of its proof of authority signer is loaded.
+func (m *Manager) UpdateEngine(key string, engine consensus.Consensus) error {
+	managed, err := m.get(key)
+	if err != nil {
+		return err
+	}
+	managed.lock.Lock()
+	defer managed.lock.Unlock()
+
+	managed.engine = engine
+	return nil
+}
+
+// Append verifies and adds a sealed block to the blockchain and returns its height.
+// When another block was appended on the same tip first, v1alpha1.ErrTipMoved is
+// returned, when the required difficulty changed while mining,
+// consensus.ErrDifficultyChanged is returned.
+func (m *Manager) Append(key string, block *v1alpha1.Block) (int64, error) {
+	managed, err := m.get(key)
+	if err != nil {
+		return 0, err
+	}
+	managed.lock.Lock()
+	defer managed.lock.Unlock()
+
+	if err := managed.blockchain.ValidateLink(block); err != nil {
+		return 0, err
+	}
+	if err := block.ValidateMerkleRoot(); err != nil {
+		return 0, err
+	}
+	if err := managed.engine.Verify(managed.blockchain, block); err != nil {
+		return 0, err
+	}
+	// Apply the block to a copy of the ledger, which is only kept once the block
+	// is part of both the block tree and the main chain.
+	var state ledger.Ledger
+	if managed.state != nil {
+		_, height := managed.blockchain.Tip()
+		state = managed.state.Clone()
+		if err := state.Apply(block, height); err != nil {
+			return 0, err
+		}
+	}
+	// Keep a private copy so callers can keep using their block.
+	block = block.DeepCopy()
+	if _, err := managed.tree.Add(block); err != nil {
+		return 0, err
+	}
+	height, err := managed.blockchain.AddBlock(block)
+	if err != nil {
+		return 0, err
+	}
+	if state != nil {
+		managed.state = state
+	}
+	return height, nil
+}
+
+// Connect verifies and adds a sealed block anywhere in the block tree of the
+// blockchain, e.g. a block sealed outside of the controller. When the branch of
+// the block becomes the one with the most work the main chain is reorganized onto
+// it and the returned Reorg describes the change, it is nil when the main chain is
+// unchanged. v1alpha1.ErrUnknownParent is returned when the parent of the block
+// is not known.
+func (m *Manager) Connect(key string, block *v1alpha1.Block) (*v1alpha1.Reorg, error) {
+	managed, err := m.get(key)
+	if err != nil {
+		return nil, err
+	}
+	managed.lock.Lock()
+	defer managed.lock.Unlock()
+
+	return managed.connect(block)
+}
+
+func (managed *managedChain) connect(block *v1alpha1.Block) (*v1alpha1.Reorg, error) {
+	if managed.tree.Has(block.Spec.Hash) {
+		return nil, nil
+	}
+	parent := block.Spec.PrevBlockHash
+	if len(parent) > 0 && !managed.tree.Has(parent) {
+		return nil, v1alpha1.ErrUnknownParent
+	}
+
+	if err := block.ValidateMerkleRoot(); err != nil {
+		return nil, err
+	}
+	// Verify the block against the branch it extends, e.g. the difficulty
+	// required at its height depends on the timestamps of its ancestors.
+	branch := &v1alpha1.Blockchain{
+		ObjectMeta: managed.blockchain.ObjectMeta,
+		Spec:       managed.blockchain.Spec,
+		Chain:      managed.tree.Branch(parent),
+	}
+	if err := managed.engine.Verify(branch, block); err != nil {
+		return nil, err
+	}
+	// Apply the block to the ledger at its parent, which becomes the ledger at the
+	// tip if the branch of the block becomes the main chain.
+	var state ledger.Ledger
+	if managed.genesis != nil {
+		tip, _ := managed.blockchain.Tip()
+		if bytes.Equal(parent, tip) {
+			state = managed.state.Clone()
+		} else {
+			replayed, err := replay(managed.genesis, branch.Chain)
+			if err != nil {
+				return nil, err
+			}
+			state = replayed
+		}
+		if err := state.Apply(block, int64(len(branch.Chain))); err != nil {
+			return nil, err
+		}
+	}
+
+	block = block.DeepCopy()
+	best, err := managed.tree.Add(block)
+	if err != nil || !best {
+		return nil, err
+	}
+	managed.state = state
+	chain := managed.tree.Branch(block.Spec.Hash)
+	reorg := v1alpha1.NewReorg(managed.blockchain.Chain, chain)
+	if reorg.Depth() > 0 {
+		glog.Infof("Reorganizing blockchain %s at height %d, disconnecting %d blocks and connecting %d blocks",
+			managed.blockchain.Name, reorg.ForkHeight, reorg.Depth(), len(reorg.Connected))
+	}
+	managed.blockchain.Chain = chain
+	return reorg, nil
+}
+
+// Reset replaces the block tree of the blockchain with the given blocks, e.g. when
+// it is recovered, and selects the branch with the most work as the main chain. The
+// blocks are connected earliest first, so the earliest branch wins ties. It returns
+// the blocks which could not be connected, either because they are invalid under
+// the chain rules or because they do not link to any of the other blocks.
+func (m *Manager) Reset(key string, blocks []*v1alpha1.Block) ([]*v1alpha1.Block, error) {
+	managed, err := m.get(key)
+	if err != nil {
+		return nil, err
+	}
+	managed.lock.Lock()
+	defer managed.lock.Unlock()
+
+	managed.tree = v1alpha1.NewBlockTree(managed.engine.Weight)
+	managed.blockchain.Chain = nil
+	if managed.genesis != nil {
+		managed.state = managed.genesis.Clone()
+	}
+
+	pending := make([]*v1alpha1.Block, len(blocks))
+	copy(pending, blocks)
+	sort.SliceStable(pending, func(i, j int) bool {
+		if pending[i].Spec.Timestamp != pending[j].Spec.Timestamp {
+			return pending[i].Spec.Timestamp < pending[j].Spec.Timestamp
+		}
+		return pending[i].Name < pending[j].Name
+	})
+
+	// Connect the blocks whose parent is known until no more can be.
+	var rejected []*v1alpha1.Block
+	for connected := true; connected; {
+		connected = false
+		var rest []*v1alpha1.Block
+		for _, block := range pending {
+			_, err := managed.connect(block)
+			switch {
+			case err == v1alpha1.ErrUnknownParent:
+				rest = append(rest, block)
+			case err != nil:
+				glog.Warningf("Block %s/%s of blockchain %s is invalid: %v", block.Namespace, block.Name, key, err)
+				rejected = append(rejected, block)
+			default:
+				connected = true
+			}
+		}
+		pending = rest
+	}
+	return append(rejected, pending...), nil
+}
+
+// Blocks returns a copy of the blocks of the main chain of the blockchain, from the genesis block to the tip.
+func (m *Manager) Blocks(key string) ([]*v1alpha1.Block, error) {
+	managed, err := m.get(key)
+	if err != nil {
+		return nil, err
+	}
+	managed.lock.RLock()
+	defer managed.lock.RUnlock()
+
+	blocks := make([]*v1alpha1.Block, len(managed.blockchain.Chain))
+	for i, block := range managed.blockchain.Chain {
+		blocks[i] = block.DeepCopy()
+	}
+	return blocks, nil
+}
+
+// AllBlocks returns a copy of every block connected to the blockchain, including
+// the blocks of the branches which lost the fork choice, parents before their children.
+func (m *Manager) AllBlocks(key string) ([]*v1alpha1.Block, error) {
+	managed, err := m.get(key)
+	if err != nil {
+		return nil, err
+	}
+	managed.lock.RLock()
+	defer managed.lock.RUnlock()
+
+	blocks := managed.tree.Blocks()
+	for i, block := range blocks {
+		blocks[i] = block.DeepCopy()
+	}
+	return blocks, nil
+}
+
+// Find returns a copy of the block on the main chain of the blockchain which was
+// appended from the Block resource with the given name and UID, along with its
+// height, e.g. when writing it back to the API server failed. It returns false if
+// the resource was not appended.
+func (m *Manager) Find(key, name string, uid types.UID) (*v1alpha1.Block, int64, bool) {
+	managed, err := m.get(key)
+	if err != nil || uid == "" {
+		return nil, 0, false
+	}
+	managed.lock.RLock()
+	defer managed.lock.RUnlock()
+
+	// Blocks are usually looked up shortly after they were appended.
+	for height := len(managed.blockchain.Chain) - 1; height >= 0; height-- {
+		block := managed.blockchain.Chain[height]
+		if block.Name == name && block.UID == uid {
+			return block.DeepCopy(), int64(height), true
+		}
+	}
+	return nil, 0, false
+}
+
+// Keys returns the keys of all the managed blockchains.
+func (m *Manager) Keys() []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	keys := make([]string, 0, len(m.chains))
+	for key := range m.chains {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// HasBlock reports whether a block with the given hash is part of the main chain of the blockchain.
+func (m *Manager) HasBlock(key string, hash []byte) bool {
+	_, ok := m.Height(key, hash)
+	return ok
+}
+
+// Height returns the height of the block with the given hash on the main chain of
+// the blockchain, it returns false if the block is not on the main chain.
+func (m *Manager) Height(key string, hash []byte) (int64, bool) {
+	managed, err := m.get(key)
+	if err != nil || len(hash) == 0 {
+		return 0, false
+	}
+	managed.lock.RLock()
+	defer managed.lock.RUnlock()
+
+	for height, block := range managed.blockchain.Chain {
+		if bytes.Equal(block.Spec.Hash, hash) {
+			return int64(height), true
+		}
+	}
+	return 0, false
+}
+
+// RequiredDifficulty returns the difficulty the chain rules require from a block on
+// top of the block with the given hash, which may be on any branch of the blockchain.
+// v1alpha1.ErrUnknownParent is returned when that block is not known.
+func (m *Manager) RequiredDifficulty(key string, parent []byte) (int64, error) {
+	managed, err := m.get(key)
+	if err != nil {
+		return 0, err
+	}
+	managed.lock.RLock()
+	defer managed.lock.RUnlock()
+
+	if len(parent) > 0 && !managed.tree.Has(parent) {
+		return 0, v1alpha1.ErrUnknownParent
+	}
+	branch := &v1alpha1.Blockchain{Spec: managed.blockchain.Spec, Chain: managed.tree.Branch(parent)}
+	return branch.RequiredDifficulty(int64(len(branch.Chain))), nil
+}
+
+// Knows reports whether a block with the given hash was connected to the blockchain,
+// either to its main chain or to a branch which lost the fork choice.
+func (m *Manager) Knows(key string, hash []byte) bool {
+	managed, err := m.get(key)
+	if err != nil {
+		return false
+	}
+	managed.lock.RLock()
+	defer managed.lock.RUnlock()
+
+	return managed.tree.Has(hash)
+}
+
+// Status returns the status describing the blockchain.
+func (m *Manager) Status(key string) (v1alpha1.BlockchainStatus, error) {
+	managed, err := m.get(key)
+	if err != nil {
+		return v1alpha1.BlockchainStatus{}, err
+	}
+	managed.lock.RLock()
+	defer managed.lock.RUnlock()
+
+	status := managed.blockchain.ComputeStatus(managed.engine.Weight)
+	if accounts, ok := managed.state.(*ledger.Accounts); ok {
+		status.Accounts = accounts.List()
+	}
+	return status, nil
+}
+
+// ValidateTransaction checks that tx can be applied on top of the tip of the blockchain
+// once the pending transactions are. It always succeeds when the blockchain has no ledger.
+func (m *Manager) ValidateTransaction(key string, tx *v1alpha1.Transaction, pending []v1alpha1.Transaction) error {
+	managed, err := m.get(key)
+	if err != nil {
+		return err
+	}
+	managed.lock.RLock()
+	defer managed.lock.RUnlock()
+
+	if managed.state == nil {
+		return nil
+	}
+	return managed.state.Validate(tx, pending)
+}
+
+// Balance returns the value owned by address at the tip of the blockchain.
+func (m *Manager) Balance(key, address string) (int64, error) {
+	managed, err := m.get(key)
+	if err != nil {
+		return 0, err
+	}
+	managed.lock.RLock()
+	defer managed.lock.RUnlock()
+
+	if managed.state == nil {
+		return 0, fmt.Errorf("blockchain %s has no ledger", key)
+	}
+	return managed.state.Balance(address), nil
+}
+
+// UnspentOutputs returns the outputs paid to address which are unspent at the tip of
+// the blockchain, which must use the UTXO ledger.
+func (m *Manager) UnspentOutputs(key, address string) ([]v1alpha1.UnspentOutput, error) {
+	managed, err := m.get(key)
+	if err != nil {
+		return nil, err
+	}
+	managed.lock.RLock()
+	defer managed.lock.RUnlock()
+
+	utxos, ok := managed.state.(*ledger.UTXOSet)
+	if !ok {
+		return nil, fmt.Errorf("blockchain %s does not use the %s ledger", key, v1alpha1.LedgerUTXO)
+	}
+	return utxos.UnspentOutputs(address), nil
+}
+
+// Account returns the account with the given address at the tip of the blockchain,
+// which must use the account ledger.
+func (m *Manager) Account(key, address string) (v1alpha1.AccountStatus, error) {
+	managed, err := m.get(key)
+	if err != nil {
+		return v1alpha1.AccountStatus{}, err
+	}
+	managed.lock.RLock()
+	defer managed.lock.RUnlock()
+
+	accounts, ok := managed.state.(*ledger.Accounts)
+	if !ok {
+		return v1alpha1.AccountStatus{}, fmt.Errorf("blockchain %s does not use the %s ledger", key, v1alpha1.LedgerAccount)
+	}
+	return accounts.Account(address), nil
+}
+
+// replay returns the ledger resulting from applying the given blocks, from the
+// genesis block on, to a copy of genesis.
+func replay(genesis ledger.Ledger, blocks []*v1alpha1.Block) (ledger.Ledger, error) {
+	state := genesis.Clone()
+	for height, block := range blocks {
+		if err := state.Apply(block, int64(height)); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nimrodshn/kubechain/pkg/consensus"
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// mine seals a proof of work block at the given difficulty on top of parent.
+func mine(t *testing.T, name string, parent *v1alpha1.Block, difficulty, timestamp int64) *v1alpha1.Block {
+	block := &v1alpha1.Block{Spec: v1alpha1.BlockSpec{Difficulty: difficulty, Timestamp: timestamp}}
+	block.Name = name
+	if parent != nil {
+		block.Spec.PrevBlockHash = parent.Spec.Hash
+	}
+	block.SetMerkleRoot()
+	if err := block.Process(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// TestManagerResetDifficultyChanged recovers a blockchain whose difficulty was changed
+// between two of its blocks.
+func TestManagerResetDifficultyChanged(t *testing.T) {
+	genesis := mine(t, "genesis", nil, v1alpha1.MinDifficulty, 0)
+	next := mine(t, "next", genesis, v1alpha1.MinDifficulty+1, 60)
+
+	tests := []struct {
+		name     string
+		spec     v1alpha1.BlockchainSpec
+		rejected int
+	}{
+		{"fixed difficulty", v1alpha1.BlockchainSpec{Difficulty: v1alpha1.MinDifficulty + 2}, 0},
+		// Retargeting chains require the difficulty of the spec until the first retarget.
+		{"retargeted difficulty", v1alpha1.BlockchainSpec{
+			Difficulty: v1alpha1.MinDifficulty,
+			Retarget:   &v1alpha1.RetargetPolicy{Interval: 10, TargetBlockTime: metav1.Duration{Duration: time.Minute}},
+		}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager := NewManager()
+			manager.Track("ns/c", &v1alpha1.Blockchain{Spec: test.spec}, consensus.NewProofOfWork(1), nil)
+			rejected, err := manager.Reset("ns/c", []*v1alpha1.Block{next, genesis})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rejected) != test.rejected {
+				t.Fatalf("expected %d rejected blocks, got %d", test.rejected, len(rejected))
+			}
+			if _, height, _ := manager.Tip("ns/c"); height != int64(2-test.rejected) {
+				t.Fatalf("expected %d recovered blocks, got %d", 2-test.rejected, height)
+			}
+		})
+	}
+}
+
+func TestManagerFind(t *testing.T) {
+	genesis := mine(t, "genesis", nil, v1alpha1.MinDifficulty, 0)
+	genesis.UID = "genesis-uid"
+	next := mine(t, "next", genesis, v1alpha1.MinDifficulty, 60)
+	next.UID = "next-uid"
+	manager := NewManager()
+	manager.Track("ns/c", &v1alpha1.Blockchain{}, consensus.NewProofOfWork(1), nil)
+	if _, err := manager.Reset("ns/c", []*v1alpha1.Block{genesis, next}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		block  string
+		uid    types.UID
+		found  bool
+		height int64
+	}{
+		{"genesis", "genesis", "genesis-uid", true, 0},
+		{"tip", "next", "next-uid", true, 1},
+		{"recreated", "next", "other-uid", false, 0},
+		{"no uid", "next", "", false, 0},
+		{"unknown", "other", "next-uid", false, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			block, height, found := manager.Find("ns/c", test.block, test.uid)
+			if found != test.found {
+				t.Fatalf("expected found %t, got %t", test.found, found)
+			}
+			if found && (height != test.height || block.Name != test.block) {
+				t.Fatalf("expected block %s at height %d, got %s at %d", test.block, test.height, block.Name, height)
+			}
+		})
+	}
+}
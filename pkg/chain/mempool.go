@@ -0,0 +1,199 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chain
+
+import (
+	"sync"
+	"time"
+
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+)
+
+// Mempool holds the pending transactions of every blockchain until they are batched
+// into a block. Like the orphan pool it is only a cache, the transactions stay in the
+// API server and the mempool is rebuilt from them when the controller restarts.
+type Mempool struct {
+	lock sync.Mutex
+	// pending holds the pending transactions in the order they were added, keyed by
+	// the key of their blockchain.
+	pending map[string][]*PendingTransaction
+	// keys holds the keys of the pending transactions, usually namespace/name.
+	keys map[string]bool
+	// inflight holds the keys of the transactions taken from the mempool which are
+	// being batched into a block, see Done.
+	inflight map[string]bool
+}
+
+// PendingTransaction is a transaction waiting in the mempool.
+type PendingTransaction struct {
+	// Key is the key of the transaction, usually namespace/name.
+	Key         string
+	Transaction v1alpha1.Transaction
+	Added       time.Time
+}
+
+// NewMempool is a constructor for an empty mempool.
+func NewMempool() *Mempool {
+	return &Mempool{
+		pending:  make(map[string][]*PendingTransaction),
+		keys:     make(map[string]bool),
+		inflight: make(map[string]bool),
+	}
+}
+
+// Add holds the transaction with the given key, which is added to the blockchain with
+// the given key, and returns the number of transactions pending for the blockchain.
+// Adding a transaction which is already pending or being batched does nothing.
+func (m *Mempool) Add(key, chainKey string, tx v1alpha1.Transaction) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if !m.keys[key] && !m.inflight[key] {
+		m.keys[key] = true
+		m.pending[chainKey] = append(m.pending[chainKey], &PendingTransaction{Key: key, Transaction: tx, Added: time.Now()})
+	}
+	return len(m.pending[chainKey])
+}
+
+// Take removes and returns up to max of the oldest transactions pending for the
+// blockchain with the given key. The transactions are in flight until Done or
+// Return is called with them.
+func (m *Mempool) Take(chainKey string, max int) []*PendingTransaction {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	pending := m.pending[chainKey]
+	if len(pending) > max {
+		pending = pending[:max]
+	}
+	for _, tx := range pending {
+		delete(m.keys, tx.Key)
+		m.inflight[tx.Key] = true
+	}
+	if rest := m.pending[chainKey][len(pending):]; len(rest) > 0 {
+		m.pending[chainKey] = append([]*PendingTransaction(nil), rest...)
+	} else {
+		delete(m.pending, chainKey)
+	}
+	return pending
+}
+
+// Done marks the transactions taken from the mempool as batched into a block.
+func (m *Mempool) Done(batch []*PendingTransaction) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, tx := range batch {
+		delete(m.inflight, tx.Key)
+	}
+}
+
+// Return puts back the transactions taken from the mempool for the blockchain with
+// the given key, e.g. when the block they were batched into could not be created.
+func (m *Mempool) Return(chainKey string, batch []*PendingTransaction) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var returned []*PendingTransaction
+	for _, tx := range batch {
+		delete(m.inflight, tx.Key)
+		if !m.keys[tx.Key] {
+			m.keys[tx.Key] = true
+			returned = append(returned, tx)
+		}
+	}
+	if len(returned) > 0 {
+		m.pending[chainKey] = append(returned, m.pending[chainKey]...)
+	}
+}
+
+// InFlight reports whether the transaction with the given key is being batched into a block.
+func (m *Mempool) InFlight(key string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.inflight[key]
+}
+
+// Clear drops every pending transaction, e.g. when the replica stops being the leader
+// and another replica batches them.
+func (m *Mempool) Clear() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.pending = make(map[string][]*PendingTransaction)
+	m.keys = make(map[string]bool)
+}
+
+// Remove drops the transaction with the given key, e.g. when it is deleted.
+func (m *Mempool) Remove(key string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if !m.keys[key] {
+		return
+	}
+	delete(m.keys, key)
+	for chainKey, pending := range m.pending {
+		for i, tx := range pending {
+			if tx.Key != key {
+				continue
+			}
+			pending = append(pending[:i:i], pending[i+1:]...)
+			if len(pending) == 0 {
+				delete(m.pending, chainKey)
+			} else {
+				m.pending[chainKey] = pending
+			}
+			return
+		}
+	}
+}
+
+// Pending returns the transactions pending for the blockchain with the given key,
+// except the one with the key except.
+func (m *Mempool) Pending(chainKey, except string) []v1alpha1.Transaction {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var transactions []v1alpha1.Transaction
+	for _, tx := range m.pending[chainKey] {
+		if tx.Key != except {
+			transactions = append(transactions, tx.Transaction)
+		}
+	}
+	return transactions
+}
+
+// Chains returns the keys of the blockchains with pending transactions.
+func (m *Mempool) Chains() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	keys := make([]string, 0, len(m.pending))
+	for key := range m.pending {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Len returns the number of pending transactions.
+func (m *Mempool) Len() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return len(m.keys)
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chain
+
+import (
+	"reflect"
+	"testing"
+
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+)
+
+// pendingData returns the data of the transactions pending for the blockchain ns/chain.
+func pendingData(pool *Mempool) []string {
+	var data []string
+	for _, tx := range pool.Pending("ns/chain", "") {
+		data = append(data, tx.Data)
+	}
+	return data
+}
+
+func addTransaction(pool *Mempool, key string) int {
+	return pool.Add(key, "ns/chain", v1alpha1.Transaction{Data: key})
+}
+
+func TestMempoolTakeOldestFirst(t *testing.T) {
+	pool := NewMempool()
+	for _, key := range []string{"ns/a", "ns/b", "ns/c"} {
+		addTransaction(pool, key)
+	}
+	if n := addTransaction(pool, "ns/b"); n != 3 {
+		t.Fatalf("expected adding a pending transaction again to do nothing, %d are pending", n)
+	}
+
+	batch := pool.Take("ns/chain", 2)
+	if len(batch) != 2 || batch[0].Key != "ns/a" || batch[1].Key != "ns/b" {
+		t.Fatalf("expected to take ns/a and ns/b, got %d transactions", len(batch))
+	}
+	if !pool.InFlight("ns/a") || pool.InFlight("ns/c") {
+		t.Fatal("expected only the batched transactions to be in flight")
+	}
+	// Transactions being batched are not taken or added again.
+	if n := addTransaction(pool, "ns/a"); n != 1 {
+		t.Fatalf("expected 1 pending transaction, got %d", n)
+	}
+	if rest := pool.Take("ns/chain", 2); len(rest) != 1 || rest[0].Key != "ns/c" {
+		t.Fatalf("expected to take ns/c, got %d transactions", len(rest))
+	}
+	if pool.Len() != 0 || len(pool.Chains()) != 0 {
+		t.Fatalf("expected the mempool to be empty, got %d transactions for %v", pool.Len(), pool.Chains())
+	}
+
+	pool.Done(batch)
+	if pool.InFlight("ns/a") {
+		t.Fatal("expected a batched transaction not to be in flight")
+	}
+	// A transaction whose block was lost can be added again.
+	if n := addTransaction(pool, "ns/a"); n != 1 {
+		t.Fatalf("expected 1 pending transaction, got %d", n)
+	}
+}
+
+func TestMempoolReturn(t *testing.T) {
+	pool := NewMempool()
+	for _, key := range []string{"ns/a", "ns/b", "ns/c"} {
+		addTransaction(pool, key)
+	}
+	batch := pool.Take("ns/chain", 2)
+	addTransaction(pool, "ns/d")
+
+	pool.Return("ns/chain", batch)
+	if data, expected := pendingData(pool), []string{"ns/a", "ns/b", "ns/c", "ns/d"}; !reflect.DeepEqual(data, expected) {
+		t.Fatalf("expected the returned transactions ahead of newer ones %v, got %v", expected, data)
+	}
+	if pool.InFlight("ns/a") || pool.InFlight("ns/b") {
+		t.Fatal("expected the returned transactions not to be in flight")
+	}
+}
+
+func TestMempoolRemove(t *testing.T) {
+	tests := []struct {
+		name     string
+		remove   string
+		except   string
+		expected []string
+	}{
+		{"first", "ns/a", "", []string{"ns/b", "ns/c"}},
+		{"middle", "ns/b", "", []string{"ns/a", "ns/c"}},
+		{"unknown", "ns/x", "", []string{"ns/a", "ns/b", "ns/c"}},
+		{"other excepted", "ns/a", "ns/c", []string{"ns/b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool := NewMempool()
+			for _, key := range []string{"ns/a", "ns/b", "ns/c"} {
+				addTransaction(pool, key)
+			}
+			pool.Remove(test.remove)
+			var data []string
+			for _, tx := range pool.Pending("ns/chain", test.except) {
+				data = append(data, tx.Data)
+			}
+			if !reflect.DeepEqual(data, test.expected) {
+				t.Fatalf("expected %v pending, got %v", test.expected, data)
+			}
+		})
+	}
+}
+
+func TestMempoolClear(t *testing.T) {
+	pool := NewMempool()
+	addTransaction(pool, "ns/a")
+	pool.Take("ns/chain", 1)
+	addTransaction(pool, "ns/b")
+
+	pool.Clear()
+	if pool.Len() != 0 || pendingData(pool) != nil {
+		t.Fatalf("expected no pending transaction, got %v", pendingData(pool))
+	}
+	// Clearing leaves the batch in flight to its block.
+	if !pool.InFlight("ns/a") {
+		t.Fatal("expected ns/a to remain in flight")
+	}
+}
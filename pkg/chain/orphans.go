@@ -0,0 +1,156 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chain
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+)
+
+// OrphanPool holds sealed blocks whose parent is not known yet, e.g. blocks imported
+// from another cluster out of order, until their parent is connected. The pool only
+// holds the keys of the blocks, the blocks themselves stay in the API server.
+type OrphanPool struct {
+	lock    sync.Mutex
+	maxSize int
+	ttl     time.Duration
+	// orphans holds the orphans keyed by the key of their block, usually namespace/name.
+	orphans map[string]*orphan
+	// children holds the keys of the orphans waiting for a parent, keyed by the
+	// key of the blockchain and the hash of the parent.
+	children map[string][]string
+}
+
+// orphan is a block waiting for its parent.
+type orphan struct {
+	parent string
+	added  time.Time
+}
+
+// NewOrphanPool is a constructor for an orphan pool holding at most maxSize
+// orphans for at most ttl.
+func NewOrphanPool(maxSize int, ttl time.Duration) *OrphanPool {
+	return &OrphanPool{
+		maxSize:  maxSize,
+		ttl:      ttl,
+		orphans:  make(map[string]*orphan),
+		children: make(map[string][]string),
+	}
+}
+
+func parentKey(chainKey string, hash []byte) string {
+	return chainKey + "/" + string(hash)
+}
+
+// Add holds the block with the given key, which belongs to the blockchain with
+// the given key, until its parent is connected. When the pool is full the oldest
+// orphans are evicted and their keys returned.
+func (p *OrphanPool) Add(key, chainKey string, block *v1alpha1.Block) []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.orphans[key]; ok {
+		return nil
+	}
+	parent := parentKey(chainKey, block.Spec.PrevBlockHash)
+	p.orphans[key] = &orphan{parent: parent, added: time.Now()}
+	p.children[parent] = append(p.children[parent], key)
+
+	var evicted []string
+	for len(p.orphans) > p.maxSize {
+		oldest := ""
+		for key, orphan := range p.orphans {
+			if oldest == "" || orphan.added.Before(p.orphans[oldest].added) {
+				oldest = key
+			}
+		}
+		glog.Infof("Orphan pool is full, evicting block %s", oldest)
+		p.remove(oldest)
+		evicted = append(evicted, oldest)
+	}
+	return evicted
+}
+
+// Adopt removes the orphans waiting for the block with the given hash of the
+// blockchain with the given key and returns their keys.
+func (p *OrphanPool) Adopt(chainKey string, hash []byte) []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	parent := parentKey(chainKey, hash)
+	keys := p.children[parent]
+	delete(p.children, parent)
+	for _, key := range keys {
+		delete(p.orphans, key)
+	}
+	return keys
+}
+
+// Expire removes the orphans held for longer than the ttl of the pool and returns their keys.
+func (p *OrphanPool) Expire() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var expired []string
+	for key, orphan := range p.orphans {
+		if time.Since(orphan.added) > p.ttl {
+			expired = append(expired, key)
+		}
+	}
+	for _, key := range expired {
+		p.remove(key)
+	}
+	return expired
+}
+
+// Remove removes the orphan with the given key, e.g. when its block is deleted.
+func (p *OrphanPool) Remove(key string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.remove(key)
+}
+
+// Len returns the number of orphans in the pool.
+func (p *OrphanPool) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return len(p.orphans)
+}
+
+func (p *OrphanPool) remove(key string) {
+	orphan, ok := p.orphans[key]
+	if !ok {
+		return
+	}
+	delete(p.orphans, key)
+	// Build a new slice, the previous one may have been handed out by Adopt.
+	var siblings []string
+	for _, sibling := range p.children[orphan.parent] {
+		if sibling != key {
+			siblings = append(siblings, sibling)
+		}
+	}
+	if len(siblings) == 0 {
+		delete(p.children, orphan.parent)
+	} else {
+		p.children[orphan.parent] = siblings
+	}
+}
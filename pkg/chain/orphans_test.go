@@ -0,0 +1,117 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chain
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+)
+
+func orphanOf(parent string) *v1alpha1.Block {
+	return &v1alpha1.Block{Spec: v1alpha1.BlockSpec{PrevBlockHash: []byte(parent)}}
+}
+
+func sorted(keys []string) []string {
+	sort.Strings(keys)
+	return keys
+}
+
+func TestOrphanPoolAdopt(t *testing.T) {
+	tests := []struct {
+		name     string
+		orphans  map[string]string
+		parent   string
+		adopted  []string
+		remained int
+	}{
+		{"no children", map[string]string{"ns/a": "x"}, "y", nil, 1},
+		{"single child", map[string]string{"ns/a": "x"}, "x", []string{"ns/a"}, 0},
+		{"siblings", map[string]string{"ns/a": "x", "ns/b": "x", "ns/c": "x"}, "x", []string{"ns/a", "ns/b", "ns/c"}, 0},
+		{"cousins", map[string]string{"ns/a": "x", "ns/b": "x", "ns/c": "y"}, "x", []string{"ns/a", "ns/b"}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool := NewOrphanPool(10, time.Hour)
+			for key, parent := range test.orphans {
+				pool.Add(key, "ns/chain", orphanOf(parent))
+			}
+			adopted := sorted(pool.Adopt("ns/chain", []byte(test.parent)))
+			if !reflect.DeepEqual(adopted, test.adopted) {
+				t.Fatalf("expected %v to be adopted, got %v", test.adopted, adopted)
+			}
+			if pool.Len() != test.remained {
+				t.Fatalf("expected %d orphans to remain, got %d", test.remained, pool.Len())
+			}
+			if again := pool.Adopt("ns/chain", []byte(test.parent)); len(again) != 0 {
+				t.Fatalf("expected the orphans to be adopted once, got %v again", again)
+			}
+		})
+	}
+}
+
+func TestOrphanPoolRemoveSibling(t *testing.T) {
+	pool := NewOrphanPool(10, time.Hour)
+	for _, key := range []string{"ns/a", "ns/b", "ns/c"} {
+		pool.Add(key, "ns/chain", orphanOf("x"))
+	}
+	pool.Remove("ns/b")
+	adopted := sorted(pool.Adopt("ns/chain", []byte("x")))
+	if expected := []string{"ns/a", "ns/c"}; !reflect.DeepEqual(adopted, expected) {
+		t.Fatalf("expected %v to be adopted, got %v", expected, adopted)
+	}
+}
+
+func TestOrphanPoolExpire(t *testing.T) {
+	pool := NewOrphanPool(10, time.Minute)
+	pool.Add("ns/old", "ns/chain", orphanOf("x"))
+	pool.Add("ns/new", "ns/chain", orphanOf("x"))
+	pool.orphans["ns/old"].added = time.Now().Add(-2 * time.Minute)
+
+	if expired := pool.Expire(); !reflect.DeepEqual(expired, []string{"ns/old"}) {
+		t.Fatalf("expected ns/old to expire, got %v", expired)
+	}
+	if adopted := pool.Adopt("ns/chain", []byte("x")); !reflect.DeepEqual(adopted, []string{"ns/new"}) {
+		t.Fatalf("expected only ns/new to be adopted, got %v", adopted)
+	}
+}
+
+func TestOrphanPoolMaxSize(t *testing.T) {
+	pool := NewOrphanPool(2, time.Hour)
+	now := time.Now()
+	for i, key := range []string{"ns/a", "ns/b"} {
+		if evicted := pool.Add(key, "ns/chain", orphanOf("x")); len(evicted) != 0 {
+			t.Fatalf("expected nothing to be evicted, got %v", evicted)
+		}
+		pool.orphans[key].added = now.Add(time.Duration(i) * time.Second)
+	}
+	if evicted := pool.Add("ns/a", "ns/chain", orphanOf("x")); len(evicted) != 0 {
+		t.Fatalf("expected adding an orphan twice to evict nothing, got %v", evicted)
+	}
+	evicted := pool.Add("ns/c", "ns/chain", orphanOf("y"))
+	if !reflect.DeepEqual(evicted, []string{"ns/a"}) {
+		t.Fatalf("expected the oldest orphan to be evicted, got %v", evicted)
+	}
+	if pool.Len() != 2 {
+		t.Fatalf("expected the pool to hold 2 orphans, got %d", pool.Len())
+	}
+	if adopted := pool.Adopt("ns/chain", []byte("x")); !reflect.DeepEqual(adopted, []string{"ns/b"}) {
+		t.Fatalf("expected only ns/b to be adopted, got %v", adopted)
+	}
+}
@@ -30,6 +30,8 @@ type BlockInterface interface {
 	List(opts metav1.ListOptions) (*v1alpha1.BlockList, error)
 	Get(name string, options metav1.GetOptions) (*v1alpha1.Block, error)
 	Create(*v1alpha1.Block) (*v1alpha1.Block, error)
+	Update(*v1alpha1.Block) (*v1alpha1.Block, error)
+	UpdateStatus(*v1alpha1.Block) (*v1alpha1.Block, error)
 	Watch(opts metav1.ListOptions) (watch.Interface, error)
 	Delete(name string, options *metav1.DeleteOptions) error
 }
@@ -101,4 +103,31 @@ func (c *blockClient) Delete(name string, options *metav1.DeleteOptions) error {
 		Error()
 }
 
-// TODO: add patch here.
+func (c *blockClient) Update(block *v1alpha1.Block) (*v1alpha1.Block, error) {
+	result := v1alpha1.Block{}
+	err := c.restClient.
+		Put().
+		Namespace(c.ns).
+		Resource("blocks").
+		Name(block.Name).
+		Body(block).
+		Do().
+		Into(&result)
+
+	return &result, err
+}
+
+func (c *blockClient) UpdateStatus(block *v1alpha1.Block) (*v1alpha1.Block, error) {
+	result := v1alpha1.Block{}
+	err := c.restClient.
+		Put().
+		Namespace(c.ns).
+		Resource("blocks").
+		Name(block.Name).
+		SubResource("status").
+		Body(block).
+		Do().
+		Into(&result)
+
+	return &result, err
+}
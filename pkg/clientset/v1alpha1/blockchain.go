@@ -0,0 +1,133 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+)
+
+// BlockchainInterface is the interface for CRUD actions on blockchains
+type BlockchainInterface interface {
+	List(opts metav1.ListOptions) (*v1alpha1.BlockchainList, error)
+	Get(name string, options metav1.GetOptions) (*v1alpha1.Blockchain, error)
+	Create(*v1alpha1.Blockchain) (*v1alpha1.Blockchain, error)
+	Update(*v1alpha1.Blockchain) (*v1alpha1.Blockchain, error)
+	UpdateStatus(*v1alpha1.Blockchain) (*v1alpha1.Blockchain, error)
+	Watch(opts metav1.ListOptions) (watch.Interface, error)
+	Delete(name string, options *metav1.DeleteOptions) error
+}
+
+// blockchainClient implements BlockchainInterface for the namespace ns.
+type blockchainClient struct {
+	restClient rest.Interface
+	ns         string
+}
+
+func (c *blockchainClient) List(opts metav1.ListOptions) (*v1alpha1.BlockchainList, error) {
+	result := v1alpha1.BlockchainList{}
+	err := c.restClient.
+		Get().
+		Namespace(c.ns).
+		Resource("blockchains").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Do().
+		Into(&result)
+
+	return &result, err
+}
+
+func (c *blockchainClient) Get(name string, opts metav1.GetOptions) (*v1alpha1.Blockchain, error) {
+	result := v1alpha1.Blockchain{}
+	err := c.restClient.
+		Get().
+		Namespace(c.ns).
+		Resource("blockchains").
+		Name(name).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Do().
+		Into(&result)
+
+	return &result, err
+}
+
+func (c *blockchainClient) Create(blockchain *v1alpha1.Blockchain) (*v1alpha1.Blockchain, error) {
+	result := v1alpha1.Blockchain{}
+	err := c.restClient.
+		Post().
+		Namespace(c.ns).
+		Resource("blockchains").
+		Body(blockchain).
+		Do().
+		Into(&result)
+
+	return &result, err
+}
+
+func (c *blockchainClient) Update(blockchain *v1alpha1.Blockchain) (*v1alpha1.Blockchain, error) {
+	result := v1alpha1.Blockchain{}
+	err := c.restClient.
+		Put().
+		Namespace(c.ns).
+		Resource("blockchains").
+		Name(blockchain.Name).
+		Body(blockchain).
+		Do().
+		Into(&result)
+
+	return &result, err
+}
+
+func (c *blockchainClient) UpdateStatus(blockchain *v1alpha1.Blockchain) (*v1alpha1.Blockchain, error) {
+	result := v1alpha1.Blockchain{}
+	err := c.restClient.
+		Put().
+		Namespace(c.ns).
+		Resource("blockchains").
+		Name(blockchain.Name).
+		SubResource("status").
+		Body(blockchain).
+		Do().
+		Into(&result)
+
+	return &result, err
+}
+
+func (c *blockchainClient) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	opts.Watch = true
+	return c.restClient.
+		Get().
+		Namespace(c.ns).
+		Resource("blockchains").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Watch()
+}
+
+func (c *blockchainClient) Delete(name string, options *metav1.DeleteOptions) error {
+	return c.restClient.
+		Delete().
+		Namespace(c.ns).
+		Resource("blockchains").
+		Name(name).
+		Body(options).
+		Do().
+		Error()
+}
@@ -26,10 +26,12 @@ import (
 // KubechainV1Alpha1Interface is an entrypoint for our client.
 type KubechainV1Alpha1Interface interface {
 	Block(namespace string) BlockInterface
+	Blockchain(namespace string) BlockchainInterface
+	Transaction(namespace string) TransactionInterface
 }
 
 // KubechainV1Alpha1Client implements KubechainV1Alpha1Interface
-// and is the entrypoint for all CRUD operations on the "Block" resource.
+// and is the entrypoint for all CRUD operations on the "Block", "Blockchain" and "Transaction" resources.
 type KubechainV1Alpha1Client struct {
 	restClient rest.Interface
 }
@@ -59,3 +61,19 @@ func (c *KubechainV1Alpha1Client) Block(namespace string) BlockInterface {
 		ns:         namespace,
 	}
 }
+
+// Blockchain creates a returns a client adhering to the BlockchainInterface. (see blockchain.go)
+func (c *KubechainV1Alpha1Client) Blockchain(namespace string) BlockchainInterface {
+	return &blockchainClient{
+		restClient: c.restClient,
+		ns:         namespace,
+	}
+}
+
+// Transaction creates a returns a client adhering to the TransactionInterface. (see transaction.go)
+func (c *KubechainV1Alpha1Client) Transaction(namespace string) TransactionInterface {
+	return &transactionClient{
+		restClient: c.restClient,
+		ns:         namespace,
+	}
+}
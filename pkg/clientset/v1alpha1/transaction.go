@@ -0,0 +1,133 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+)
+
+// TransactionInterface is the interface for CRUD actions on transactions
+type TransactionInterface interface {
+	List(opts metav1.ListOptions) (*v1alpha1.TransactionRequestList, error)
+	Get(name string, options metav1.GetOptions) (*v1alpha1.TransactionRequest, error)
+	Create(*v1alpha1.TransactionRequest) (*v1alpha1.TransactionRequest, error)
+	Update(*v1alpha1.TransactionRequest) (*v1alpha1.TransactionRequest, error)
+	UpdateStatus(*v1alpha1.TransactionRequest) (*v1alpha1.TransactionRequest, error)
+	Watch(opts metav1.ListOptions) (watch.Interface, error)
+	Delete(name string, options *metav1.DeleteOptions) error
+}
+
+// transactionClient implements TransactionInterface for the namespace ns.
+type transactionClient struct {
+	restClient rest.Interface
+	ns         string
+}
+
+func (c *transactionClient) List(opts metav1.ListOptions) (*v1alpha1.TransactionRequestList, error) {
+	result := v1alpha1.TransactionRequestList{}
+	err := c.restClient.
+		Get().
+		Namespace(c.ns).
+		Resource("transactions").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Do().
+		Into(&result)
+
+	return &result, err
+}
+
+func (c *transactionClient) Get(name string, opts metav1.GetOptions) (*v1alpha1.TransactionRequest, error) {
+	result := v1alpha1.TransactionRequest{}
+	err := c.restClient.
+		Get().
+		Namespace(c.ns).
+		Resource("transactions").
+		Name(name).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Do().
+		Into(&result)
+
+	return &result, err
+}
+
+func (c *transactionClient) Create(transaction *v1alpha1.TransactionRequest) (*v1alpha1.TransactionRequest, error) {
+	result := v1alpha1.TransactionRequest{}
+	err := c.restClient.
+		Post().
+		Namespace(c.ns).
+		Resource("transactions").
+		Body(transaction).
+		Do().
+		Into(&result)
+
+	return &result, err
+}
+
+func (c *transactionClient) Update(transaction *v1alpha1.TransactionRequest) (*v1alpha1.TransactionRequest, error) {
+	result := v1alpha1.TransactionRequest{}
+	err := c.restClient.
+		Put().
+		Namespace(c.ns).
+		Resource("transactions").
+		Name(transaction.Name).
+		Body(transaction).
+		Do().
+		Into(&result)
+
+	return &result, err
+}
+
+func (c *transactionClient) UpdateStatus(transaction *v1alpha1.TransactionRequest) (*v1alpha1.TransactionRequest, error) {
+	result := v1alpha1.TransactionRequest{}
+	err := c.restClient.
+		Put().
+		Namespace(c.ns).
+		Resource("transactions").
+		Name(transaction.Name).
+		SubResource("status").
+		Body(transaction).
+		Do().
+		Into(&result)
+
+	return &result, err
+}
+
+func (c *transactionClient) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	opts.Watch = true
+	return c.restClient.
+		Get().
+		Namespace(c.ns).
+		Resource("transactions").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Watch()
+}
+
+func (c *transactionClient) Delete(name string, options *metav1.DeleteOptions) error {
+	return c.restClient.
+		Delete().
+		Namespace(c.ns).
+		Resource("transactions").
+		Name(name).
+		Body(options).
+		Do().
+		Error()
+}
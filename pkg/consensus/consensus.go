@@ -0,0 +1,122 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package consensus defines how the blocks of a blockchain are sealed and verified.
+package consensus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+)
+
+// ErrDifficultyChanged is returned when a block was sealed with a difficulty
+// other than the one the chain rules require at its height.
+var ErrDifficultyChanged = errors.New("the block was not sealed with the difficulty required at its height")
+
+// ErrInvalidSeal is returned when the seal of a block does not match its header.
+var ErrInvalidSeal = errors.New("the block does not carry a valid seal")
+
+// Consensus seals new blocks and verifies sealed ones for a blockchain.
+// Implementations must be safe for concurrent use.
+type Consensus interface {
+	// Prepare fills in the consensus fields of the header of block (e.g. its
+	// difficulty) for it to be appended on top of chain. The link to the tip
+	// and the timestamp are already set.
+	Prepare(chain *v1alpha1.Blockchain, block *v1alpha1.Block) error
+	// Seal fills in the fields proving block may be appended (e.g. its hash
+	// and nonce). It must return the context error as soon as ctx is done.
+	Seal(ctx context.Context, block *v1alpha1.Block) error
+	// Verify checks that block, which links to the tip of chain, is validly sealed.
+	Verify(chain *v1alpha1.Blockchain, block *v1alpha1.Block) error
+	// Weight is the chain selection rule: it returns what block adds to the
+	// weight of its chain, the chain with the most cumulative weight is preferred.
+	Weight(block *v1alpha1.Block) *big.Int
+}
+
+// Config holds the controller-wide settings of the consensus engines.
+type Config struct {
+	// MiningWorkers is the number of goroutines the proof of work uses to mine a single block.
+	MiningWorkers int
+	// SecretData returns the data of the named Secret, it is used to read the
+	// keys of proof of authority signers.
+	SecretData func(namespace, name string) (map[string][]byte, error)
+}
+
+// New returns the consensus engine the blockchain is configured with.
+func New(blockchain *v1alpha1.Blockchain, config Config) (Consensus, error) {
+	switch blockchain.Spec.Consensus {
+	case "", v1alpha1.ConsensusProofOfWork:
+		return NewProofOfWork(config.MiningWorkers), nil
+	case v1alpha1.ConsensusProofOfAuthority:
+		return newProofOfAuthority(blockchain, config)
+	default:
+		return nil, fmt.Errorf("blockchain %s/%s uses unknown consensus %q", blockchain.Namespace, blockchain.Name, blockchain.Spec.Consensus)
+	}
+}
+
+// NewVerifier returns the consensus engine the blockchain is configured with but,
+// unlike New, never reads the key of its proof of authority signer. The engine
+// verifies blocks but cannot seal proof of authority blocks.
+func NewVerifier(blockchain *v1alpha1.Blockchain, config Config) (Consensus, error) {
+	if blockchain.Spec.Authority != nil {
+		blockchain = blockchain.DeepCopy()
+		blockchain.Spec.Authority.SignerSecret = ""
+	}
+	return New(blockchain, config)
+}
+
+// newProofOfAuthority reads the signer key of the blockchain, if any, from its Secret.
+func newProofOfAuthority(blockchain *v1alpha1.Blockchain, config Config) (Consensus, error) {
+	authority := blockchain.Spec.Authority
+	if authority == nil || len(authority.Signers) == 0 {
+		return nil, fmt.Errorf("blockchain %s/%s uses %s but declares no signers", blockchain.Namespace, blockchain.Name, v1alpha1.ConsensusProofOfAuthority)
+	}
+	if authority.SignerSecret == "" {
+		return NewProofOfAuthority(nil), nil
+	}
+	if config.SecretData == nil {
+		return nil, fmt.Errorf("cannot read signer secret %s/%s", blockchain.Namespace, authority.SignerSecret)
+	}
+
+	data, err := config.SecretData(blockchain.Namespace, authority.SignerSecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signer secret %s/%s: %v", blockchain.Namespace, authority.SignerSecret, err)
+	}
+	key, err := ParsePrivateKey(data[SignerKeySecretKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signer secret %s/%s: %v", blockchain.Namespace, authority.SignerSecret, err)
+	}
+	return NewProofOfAuthority(key), nil
+}
+
+// IsInvalidBlock reports whether err means a block breaks the rules of its blockchain,
+// as opposed to a failure which may be retried.
+func IsInvalidBlock(err error) bool {
+	return err == ErrInvalidSeal || err == ErrDifficultyChanged || err == ErrUnauthorizedSigner ||
+		err == v1alpha1.ErrInvalidMerkleRoot
+}
+
+// ChainWeight returns the cumulative weight of blocks under engine.
+func ChainWeight(engine Consensus, blocks []*v1alpha1.Block) *big.Int {
+	weight := new(big.Int)
+	for _, block := range blocks {
+		weight.Add(weight, engine.Weight(block))
+	}
+	return weight
+}
@@ -0,0 +1,134 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consensus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+	"golang.org/x/crypto/ed25519"
+)
+
+// SignerKeySecretKey is the entry of the signer Secret holding the ed25519
+// private key, either the 64 byte private key or its 32 byte seed.
+const SignerKeySecretKey = "privateKey"
+
+// ErrUnauthorizedSigner is returned when a block is not signed by a signer
+// authorized at its height.
+var ErrUnauthorizedSigner = errors.New("the block is not signed by a signer authorized at its height")
+
+// ProofOfAuthority seals blocks by signing their hash with the key of one of the
+// signers declared on the blockchain. Signers are rotated by bounding the heights
+// they may sign at, see v1alpha1.AuthoritySigner.
+type ProofOfAuthority struct {
+	// key is the private key the controller signs with, nil when it only verifies blocks.
+	key ed25519.PrivateKey
+}
+
+// NewProofOfAuthority is a constructor for the proof of authority consensus,
+// key may be nil for a controller which only verifies blocks.
+func NewProofOfAuthority(key ed25519.PrivateKey) *ProofOfAuthority {
+	return &ProofOfAuthority{key: key}
+}
+
+// Prepare records the signer of the controller in the block header.
+func (e *ProofOfAuthority) Prepare(chain *v1alpha1.Blockchain, block *v1alpha1.Block) error {
+	if e.key == nil {
+		return fmt.Errorf("blockchain %s has no signer secret, blocks cannot be sealed", chain.Name)
+	}
+	_, height := chain.Tip()
+	public := e.key.Public().(ed25519.PublicKey)
+	signer := findSigner(chain, height, func(s *v1alpha1.AuthoritySigner) bool {
+		return bytes.Equal(s.PublicKey, public)
+	})
+	if signer == nil {
+		return fmt.Errorf("the signer key of blockchain %s is not authorized at height %d", chain.Name, height)
+	}
+
+	block.Spec.Signer = signer.Name
+	block.Spec.Difficulty = 0
+	block.Spec.Nonce = 0
+	return nil
+}
+
+// Seal signs the block hash.
+func (e *ProofOfAuthority) Seal(ctx context.Context, block *v1alpha1.Block) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	hash := block.AuthorityHash()
+	block.Spec.Hash = hash
+	block.Spec.Signature = ed25519.Sign(e.key, hash)
+	return nil
+}
+
+// Verify checks the block is signed by a signer authorized at its height and
+// that the signature covers its header.
+func (e *ProofOfAuthority) Verify(chain *v1alpha1.Blockchain, block *v1alpha1.Block) error {
+	_, height := chain.Tip()
+	signer := findSigner(chain, height, func(s *v1alpha1.AuthoritySigner) bool {
+		return s.Name == block.Spec.Signer
+	})
+	if signer == nil || len(signer.PublicKey) != ed25519.PublicKeySize {
+		return ErrUnauthorizedSigner
+	}
+
+	hash := block.AuthorityHash()
+	if !bytes.Equal(hash, block.Spec.Hash) {
+		return ErrInvalidSeal
+	}
+	if !ed25519.Verify(ed25519.PublicKey(signer.PublicKey), hash, block.Spec.Signature) {
+		return ErrInvalidSeal
+	}
+	return nil
+}
+
+// Weight counts every block once, the longest chain is preferred.
+func (e *ProofOfAuthority) Weight(block *v1alpha1.Block) *big.Int {
+	return big.NewInt(1)
+}
+
+// findSigner returns the first signer of the chain authorized at height matching match.
+func findSigner(chain *v1alpha1.Blockchain, height int64, match func(*v1alpha1.AuthoritySigner) bool) *v1alpha1.AuthoritySigner {
+	if chain.Spec.Authority == nil {
+		return nil
+	}
+	for i := range chain.Spec.Authority.Signers {
+		signer := &chain.Spec.Authority.Signers[i]
+		if signer.AuthorizedAt(height) && match(signer) {
+			return signer
+		}
+	}
+	return nil
+}
+
+// ParsePrivateKey parses the content of the signer Secret entry, either the
+// 64 byte ed25519 private key or its 32 byte seed.
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	switch len(data) {
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(data), nil
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(data), nil
+	default:
+		return nil, fmt.Errorf("expected an ed25519 private key of %d bytes or a seed of %d bytes, got %d bytes",
+			ed25519.PrivateKeySize, ed25519.SeedSize, len(data))
+	}
+}
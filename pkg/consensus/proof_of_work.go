@@ -0,0 +1,66 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consensus
+
+import (
+	"context"
+	"math/big"
+
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+)
+
+// ProofOfWork seals blocks by mining a hash with as many leading zero bits as the
+// difficulty the chain rules require, see v1alpha1.ProofOfWork.
+type ProofOfWork struct {
+	// workers is the number of goroutines used to mine a single block.
+	workers int
+}
+
+// NewProofOfWork is a constructor for the proof of work consensus.
+func NewProofOfWork(workers int) *ProofOfWork {
+	return &ProofOfWork{workers: workers}
+}
+
+// Prepare records the difficulty the chain rules require in the block header.
+func (e *ProofOfWork) Prepare(chain *v1alpha1.Blockchain, block *v1alpha1.Block) error {
+	block.Spec.Difficulty = chain.NextDifficulty()
+	return nil
+}
+
+// Seal mines the block.
+func (e *ProofOfWork) Seal(ctx context.Context, block *v1alpha1.Block) error {
+	return block.Process(ctx, e.workers)
+}
+
+// Verify checks the block hash meets the difficulty recorded in its header, which
+// must be within [v1alpha1.MinDifficulty, v1alpha1.MaxDifficulty]. Chains which
+// retarget their difficulty must also record the difficulty required at the height
+// of the block, the height of the next block of chain. Chains with a fixed difficulty
+// may change it over time, so their blocks are only held to the difficulty bounds.
+func (e *ProofOfWork) Verify(chain *v1alpha1.Blockchain, block *v1alpha1.Block) error {
+	if chain.Spec.Retarget != nil && block.Difficulty() != chain.NextDifficulty() {
+		return ErrDifficultyChanged
+	}
+	if !v1alpha1.NewProofOfWork(block).Validate() {
+		return ErrInvalidSeal
+	}
+	return nil
+}
+
+// Weight returns the expected number of hashes needed to mine the block.
+func (e *ProofOfWork) Weight(block *v1alpha1.Block) *big.Int {
+	return v1alpha1.Work(block.Difficulty())
+}
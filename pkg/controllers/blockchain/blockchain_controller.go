@@ -17,7 +17,10 @@ package blockchain
 
 import (
 	"github.com/golang/glog"
+	"github.com/nimrodshn/kubechain/pkg/chain"
 	clientset "github.com/nimrodshn/kubechain/pkg/clientset/v1alpha1"
+	"github.com/nimrodshn/kubechain/pkg/consensus"
+	"github.com/nimrodshn/kubechain/pkg/ledger"
 	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -26,30 +29,99 @@ import (
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/client-go/util/workqueue"
 
+	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const timeout = time.Minute * 2
 
+// The number of times a block is retried before it is dropped from the queue.
+const maxRetries = 5
+
 // Controller is the custom controller for the blockchain CRD.
 type Controller struct {
-	queue      workqueue.RateLimitingInterface
-	informer   cache.SharedIndexInformer
-	blockchain *v1alpha1.Blockchain
-	clientset  clientset.KubechainV1Alpha1Interface
+	queue         workqueue.RateLimitingInterface
+	informer      cache.SharedIndexInformer
+	chainInformer cache.SharedIndexInformer
+	txInformer    cache.SharedIndexInformer
+	clientset     clientset.KubechainV1Alpha1Interface
+	recorder      record.EventRecorder
+
+	// chains holds the in-memory state of every blockchain, keyed by namespace/name.
+	chains *chain.Manager
+
+	// consensusConfig configures the consensus engines of the blockchains.
+	consensusConfig consensus.Config
+
+	// ledgerConfig configures the ledgers of the blockchains.
+	ledgerConfig ledger.Config
+
+	// orphans holds the sealed blocks whose parent is not known yet.
+	orphans *chain.OrphanPool
+
+	// txQueue holds the keys of the transactions to process.
+	txQueue workqueue.RateLimitingInterface
+
+	// mempool holds the pending transactions until they are batched into blocks.
+	mempool *chain.Mempool
+
+	// batching configures how pending transactions are batched into blocks.
+	batching BatchConfig
+
+	// replicator replicates the blockchains across controller replicas, nil when
+	// the controller runs standalone.
+	replicator Replicator
+
+	// mining holds the cancel functions of the blocks currently being mined, keyed by namespace/name.
+	miningLock sync.Mutex
+	mining     map[string]context.CancelFunc
+
+	// ready is set, atomically, while the controller runs with its blockchains recovered.
+	ready int32
 }
 
 // NewController is a constructor for the block controller.
 func NewController(queue workqueue.RateLimitingInterface,
 	informer cache.SharedIndexInformer,
-	blockchain *v1alpha1.Blockchain,
-	clientSet clientset.KubechainV1Alpha1Interface) *Controller {
+	chainInformer cache.SharedIndexInformer,
+	txInformer cache.SharedIndexInformer,
+	clientSet clientset.KubechainV1Alpha1Interface,
+	recorder record.EventRecorder,
+	consensusConfig consensus.Config,
+	ledgerConfig ledger.Config,
+	orphans *chain.OrphanPool,
+	batching BatchConfig,
+	replicator Replicator) *Controller {
+	c := &Controller{
+		informer:        informer,
+		chainInformer:   chainInformer,
+		txInformer:      txInformer,
+		queue:           queue,
+		txQueue:         workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		mempool:         chain.NewMempool(),
+		batching:        batching,
+		clientset:       clientSet,
+		recorder:        recorder,
+		chains:          chain.NewManager(),
+		consensusConfig: consensusConfig,
+		ledgerConfig:    ledgerConfig,
+		orphans:         orphans,
+		replicator:      replicator,
+		mining:          make(map[string]context.CancelFunc),
+	}
 	informer.AddEventHandler(
 		cache.ResourceEventHandlerFuncs{
 			AddFunc: func(obj interface{}) {
+				if block, ok := obj.(*v1alpha1.Block); ok && !queuedOnAdd(block) {
+					return
+				}
 				var key string
 				var err error
 				if key, err = cache.MetaNamespaceKeyFunc(obj); err != nil {
@@ -58,16 +130,86 @@ func NewController(queue workqueue.RateLimitingInterface,
 				}
 				queue.Add(key)
 			},
+			UpdateFunc: func(old, new interface{}) {
+				oldBlock, ok := old.(*v1alpha1.Block)
+				if !ok {
+					return
+				}
+				newBlock, ok := new.(*v1alpha1.Block)
+				if !ok {
+					return
+				}
+				// Let the transactions batched into the block follow its phase.
+				if newBlock.Status.Phase != oldBlock.Status.Phase {
+					c.queueBlockTransactions(newBlock)
+				}
+				// Sealed blocks being deleted wait for the controller to let them go.
+				if newBlock.DeletionTimestamp != nil && hasFinalizer(newBlock) {
+					key, err := cache.MetaNamespaceKeyFunc(newBlock)
+					if err != nil {
+						runtime.HandleError(err)
+						return
+					}
+					queue.Add(key)
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+				if err != nil {
+					runtime.HandleError(err)
+					return
+				}
+				c.cancelMining(key)
+				orphans.Remove(key)
+				c.queueBlockTransactions(obj)
+			},
 		})
-	return &Controller{
-		informer:   informer,
-		queue:      queue,
-		blockchain: blockchain,
-		clientset:  clientSet,
-	}
+	chainInformer.AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(old, new interface{}) {
+				blockchain, ok := new.(*v1alpha1.Blockchain)
+				if !ok {
+					return
+				}
+				key, err := cache.MetaNamespaceKeyFunc(blockchain)
+				if err != nil {
+					runtime.HandleError(err)
+					return
+				}
+				// Untracked blockchains pick up their spec when they are first used, the
+				// spec of replicated ones changes through the raft log, see trackReplicated.
+				if !c.chains.IsTracked(key) || c.replicator != nil {
+					return
+				}
+				engine, err := consensus.New(blockchain, c.consensusConfig)
+				if err != nil {
+					runtime.HandleError(err)
+					return
+				}
+				c.chains.UpdateSpec(key, blockchain.Spec, engine)
+			},
+		})
+	txInformer.AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				c.queueTransaction(obj)
+			},
+			UpdateFunc: func(old, new interface{}) {
+				c.queueTransaction(new)
+			},
+			DeleteFunc: func(obj interface{}) {
+				c.queueTransaction(obj)
+			},
+		})
+	return c
+}
+
+// Chains returns the in-memory state of the blockchains, e.g. to serve queries about their ledgers.
+func (c *Controller) Chains() *chain.Manager {
+	return c.chains
 }
 
-func (c *Controller) processNextItem() bool {
+func (c *Controller) processNextItem(ctx context.Context) bool {
 	// Wait until there is a new item in the working queue
 	key, quit := c.queue.Get()
 	if quit {
@@ -80,7 +222,7 @@ func (c *Controller) processNextItem() bool {
 	defer c.queue.Done(key)
 
 	// Invoke the method containing the business logic
-	err := c.addBlockEventHandler(key.(string), c.informer.GetIndexer())
+	err := c.addBlockEventHandler(ctx, key.(string), c.informer.GetIndexer())
 	if err == nil {
 		// Forget about the #AddRateLimited history of the key on every successful synchronization.
 		// This ensures that future processing of updates for this key is not delayed because of
@@ -88,6 +230,15 @@ func (c *Controller) processNextItem() bool {
 		c.queue.Forget(key)
 		return true
 	}
+
+	if c.queue.NumRequeues(key) < maxRetries {
+		glog.Infof("Error processing block %v, retrying: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+
+	c.queue.Forget(key)
+	runtime.HandleError(fmt.Errorf("dropping block %q out of the queue: %v", key, err))
 	return true
 }
 
@@ -109,63 +260,357 @@ func NewInformer(ns string, clientSet clientset.KubechainV1Alpha1Interface, queu
 	return informer
 }
 
-func (c *Controller) addBlockEventHandler(key string, indexer cache.Indexer) error {
+// NewBlockchainInformer Creates a new informer for the Blockchain crd.
+func NewBlockchainInformer(ns string, clientSet clientset.KubechainV1Alpha1Interface) cache.SharedIndexInformer {
+	informer := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(lo metav1.ListOptions) (result k8sruntime.Object, err error) {
+				return clientSet.Blockchain(ns).List(lo)
+			},
+			WatchFunc: func(lo metav1.ListOptions) (watch.Interface, error) {
+				return clientSet.Blockchain(ns).Watch(lo)
+			},
+		},
+		&v1alpha1.Blockchain{},
+		1*time.Minute,
+		cache.Indexers{},
+	)
+	return informer
+}
+
+// queuedOnAdd reports whether a block listed or created is queued. Sealed blocks are
+// recovered on startup rather than mined again and failed blocks are never mined
+// again, unless they are being deleted.
+func queuedOnAdd(block *v1alpha1.Block) bool {
+	if block.DeletionTimestamp != nil {
+		return true
+	}
+	switch block.Status.Phase {
+	case v1alpha1.BlockMined, v1alpha1.BlockStale, v1alpha1.BlockFailed:
+		return false
+	}
+	return true
+}
+
+func (c *Controller) addBlockEventHandler(ctx context.Context, key string, indexer cache.Indexer) error {
 
 	item, exists, err := indexer.GetByKey(key)
 	if err != nil {
 		glog.Errorf("Fetching object with key %s from store failed with %v", key, err)
 		return err
 	} else if !exists {
-		return fmt.Errorf("Block %s does not exist anymore", key)
+		glog.Infof("Block %s does not exist anymore", key)
+		return nil
 	}
-	block, ok := item.(*v1alpha1.Block)
+	cached, ok := item.(*v1alpha1.Block)
 	if !ok {
 		return fmt.Errorf("An error occured! expected a resource of type block instead got %T", item)
 	}
+	// Only the leader mines, it queues the pending blocks when it takes over.
+	if !c.leading() {
+		glog.Infof("Not the leader, leaving block %s to the leader", key)
+		return nil
+	}
+
+	// Failed blocks, e.g. rejected when their blockchain was recovered, are never mined again.
+	if cached.Status.Phase == v1alpha1.BlockFailed && cached.DeletionTimestamp == nil {
+		glog.Infof("Block %s failed, skipping", key)
+		return nil
+	}
+
+	// Never mutate the informer's cache, work on a copy instead.
+	block := cached.DeepCopy()
 	glog.Infof("Processing new block: %v", block)
 
-	successChan := make(chan bool)
+	chainKey, err := c.ensureBlockchain(block.Namespace, block.ChainName())
+	if err != nil {
+		glog.Errorf("Failed to get blockchain for block %s: %v", key, err)
+		return err
+	}
+	if block.DeletionTimestamp != nil {
+		return c.finalizeBlock(key, chainKey, block)
+	}
+	if block.Status.Phase == v1alpha1.BlockMined && c.chains.HasBlock(chainKey, block.Spec.Hash) {
+		glog.Infof("Block %s is already part of blockchain %s, skipping", key, chainKey)
+		return nil
+	}
+	if block.Status.Phase == v1alpha1.BlockStale && c.chains.Knows(chainKey, block.Spec.Hash) {
+		glog.Infof("Block %s is on a stale branch of blockchain %s, skipping", key, chainKey)
+		return nil
+	}
+	// Sealed blocks are connected, never mined again, even when their blockchain does
+	// not know them, e.g. mined blocks left out when it was recovered.
+	sealed := block.Status.Phase == v1alpha1.BlockMined || block.Status.Phase == v1alpha1.BlockStale
+	if block.ExternallySealed() || (sealed && len(block.Spec.Hash) > 0) {
+		return c.connectBlock(key, chainKey, block)
+	}
+
+	// The block was appended but writing it back failed, only the write back is retried
+	// rather than preparing the block again, on top of itself.
+	if appended, height, ok := c.chains.Find(chainKey, block.Name, block.UID); ok {
+		glog.Infof("Block %s is already part of blockchain %s, writing it back", key, chainKey)
+		block.Spec = appended.Spec
+		return c.completeBlock(key, chainKey, block, height)
+	}
+
+	err = c.updateBlockStatus(block, func(status *v1alpha1.BlockStatus) {
+		status.Phase = v1alpha1.BlockMining
+		status.SetCondition(v1alpha1.BlockCondition{
+			Type:    v1alpha1.BlockConditionMined,
+			Status:  v1alpha1.ConditionFalse,
+			Reason:  v1alpha1.ReasonMiningStarted,
+			Message: "Computing proof of work",
+		})
+	})
+	if err != nil {
+		glog.Errorf("Failed to update status of block %s: %v", key, err)
+		return err
+	}
 
-	// Run PoW, set Timestamp.
-	go block.Process(successChan)
+	// Fix the link to the current tip, the timestamp and the consensus fields into
+	// the header before sealing, so the seal commits to the parent of the block.
+	if _, err := c.chains.Prepare(chainKey, block); err != nil {
+		return err
+	}
+
+	// Seal the block, e.g. run PoW, set Hash and Nonce.
+	err = c.mine(ctx, key, chainKey, block)
+	if err == context.Canceled {
+		// The block was deleted or the controller is shutting down.
+		glog.Infof("Stopped mining block %s: %v", key, err)
+		return nil
+	} else if err != nil {
+		// Mining the block again would fail the same way, e.g. its
+		// difficulty is invalid or the nonce space is exhausted.
+		c.failBlock(key, block, err)
+		return nil
+	}
 
-	select {
-	case <-successChan:
-		c.blockchain.AddBlock(block)
-	case <-time.After(timeout):
-		c.purgeBlock(block)
-		return fmt.Errorf("failed to process new block - PoW exceeded timout")
+	// Appends are serialized by the chain manager, only one of the workers
+	// which mined on the same tip wins, the others are queued to be mined again.
+	height, err := c.appendBlock(chainKey, block)
+	if err == v1alpha1.ErrTipMoved || err == consensus.ErrDifficultyChanged || ledger.IsInvalidTransaction(err) {
+		glog.Infof("Block %s is stale (%v), queueing it to be mined again", key, err)
+		c.queue.Add(key)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return c.completeBlock(key, chainKey, block, height)
+}
+
+// completeBlock writes a block appended at height back to the API server, then
+// adopts its orphans and updates the status of its blockchain.
+func (c *Controller) completeBlock(key, chainKey string, block *v1alpha1.Block, height int64) error {
+	if err := c.writeMinedBlock(block, height); err != nil {
+		glog.Errorf("Failed to write mined block %s back to the API server: %v", key, err)
+		return err
+	}
+	c.adoptOrphans(chainKey, block.Spec.Hash)
+	if err := c.updateBlockchainStatus(chainKey); err != nil {
+		glog.Errorf("Failed to update status of blockchain %s: %v", chainKey, err)
+		return err
 	}
 	return nil
 }
 
+// writeMinedBlock writes the sealed fields of a block appended at height back to
+// the API server and marks it as mined.
+func (c *Controller) writeMinedBlock(block *v1alpha1.Block, height int64) error {
+	if err := c.updateBlock(block); err != nil {
+		return err
+	}
+	return c.updateBlockStatus(block, func(status *v1alpha1.BlockStatus) {
+		status.Phase = v1alpha1.BlockMined
+		status.Height = height
+		status.SetCondition(v1alpha1.BlockCondition{
+			Type:    v1alpha1.BlockConditionMined,
+			Status:  v1alpha1.ConditionTrue,
+			Reason:  v1alpha1.ReasonProofOfWorkFound,
+			Message: fmt.Sprintf("Found nonce %d", block.Spec.Nonce),
+		})
+		status.SetCondition(v1alpha1.BlockCondition{
+			Type:    v1alpha1.BlockConditionAppended,
+			Status:  v1alpha1.ConditionTrue,
+			Reason:  v1alpha1.ReasonAppendedToChain,
+			Message: fmt.Sprintf("Appended at height %d", height),
+		})
+	})
+}
+
+// mine seals block with the consensus of its blockchain until it is sealed, the
+// timeout expires, the block is deleted (see cancelMining) or ctx is cancelled.
+func (c *Controller) mine(ctx context.Context, key, chainKey string, block *v1alpha1.Block) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// The workqueue never hands the same key to two workers, so there is
+	// at most one cancel function per key.
+	c.miningLock.Lock()
+	c.mining[key] = cancel
+	c.miningLock.Unlock()
+
+	defer func() {
+		c.miningLock.Lock()
+		delete(c.mining, key)
+		c.miningLock.Unlock()
+	}()
+
+	return c.chains.Seal(ctx, chainKey, block)
+}
+
+// cancelMining stops mining the block with the given key, if it is being mined.
+func (c *Controller) cancelMining(key string) {
+	c.miningLock.Lock()
+	defer c.miningLock.Unlock()
+
+	if cancel, ok := c.mining[key]; ok {
+		glog.Infof("Block %s was deleted, cancelling mining", key)
+		cancel()
+	}
+}
+
+// cancelAllMining stops mining every block, e.g. when the replica stops being the leader.
+func (c *Controller) cancelAllMining() {
+	c.miningLock.Lock()
+	defer c.miningLock.Unlock()
+
+	for key, cancel := range c.mining {
+		glog.Infof("Cancelling mining of block %s", key)
+		cancel()
+	}
+}
+
+// failBlock marks a block which could not be mined, e.g. in time, as failed and purges it.
+func (c *Controller) failBlock(key string, block *v1alpha1.Block, cause error) {
+	reason := v1alpha1.ReasonMiningFailed
+	message := fmt.Sprintf("Mining failed: %v, purging block", cause)
+	if cause == context.DeadlineExceeded {
+		reason = v1alpha1.ReasonMiningTimeout
+		message = fmt.Sprintf("PoW exceeded the timeout of %v, purging block", timeout)
+	}
+	err := c.updateBlockStatus(block, func(status *v1alpha1.BlockStatus) {
+		status.Phase = v1alpha1.BlockFailed
+		status.SetCondition(v1alpha1.BlockCondition{
+			Type:    v1alpha1.BlockConditionMined,
+			Status:  v1alpha1.ConditionFalse,
+			Reason:  reason,
+			Message: message,
+		})
+	})
+	if err != nil {
+		glog.Errorf("Failed to update status of block %s: %v", key, err)
+	}
+	c.purgeBlock(block)
+	// The block is purged, there is nothing left to retry.
+	runtime.HandleError(fmt.Errorf("failed to process new block %s - %s", key, message))
+}
+
 // Run runs the controller
 func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) {
 	defer runtime.HandleCrash()
 
 	// Let the workers stop when we are done
 	defer c.queue.ShutDown()
+	defer c.txQueue.ShutDown()
+
+	// Cancel any mining in progress when we are done.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	go c.informer.Run(stopCh)
+	go c.chainInformer.Run(stopCh)
+	go c.txInformer.Run(stopCh)
 
 	// Wait for all involved caches to be synced, before processing items from the queue is started
-	if !cache.WaitForCacheSync(stopCh, c.informer.HasSynced) {
+	if !cache.WaitForCacheSync(stopCh, c.informer.HasSynced, c.chainInformer.HasSynced, c.txInformer.HasSynced) {
 		runtime.HandleError(fmt.Errorf("Timed out waiting for caches to sync"))
 		return
 	}
 
+	if c.replicator != nil {
+		// The replicated blockchains are recovered by whichever replica leads.
+		if err := c.replicator.Start(c.chains, c.trackReplicated, c.replicatedBlockchain); err != nil {
+			runtime.HandleError(fmt.Errorf("Failed to start replication: %v", err))
+			return
+		}
+		defer c.replicator.Shutdown()
+		go c.followLeadership(stopCh)
+	} else {
+		// Rebuild the blockchains from the persisted blocks before mining new ones.
+		c.recoverBlockchains()
+	}
+
+	atomic.StoreInt32(&c.ready, 1)
+	defer atomic.StoreInt32(&c.ready, 0)
+
+	go wait.Until(c.expireOrphans, orphanExpiryInterval, stopCh)
+	go wait.Until(c.produceBlocks, c.batching.Interval, stopCh)
+
 	for i := 0; i < threadiness; i++ {
-		go wait.Until(c.runWorker, time.Second, stopCh)
+		go wait.Until(func() { c.runWorker(ctx) }, time.Second, stopCh)
 	}
+	go wait.Until(c.runTransactionWorker, time.Second, stopCh)
 
 	<-stopCh
 }
 
-func (c *Controller) runWorker() {
-	for c.processNextItem() {
+// Ready reports whether the controller is running with its caches synced and its
+// blockchains recovered, i.e. whether queries about them can be answered. A standby
+// replica waiting for the leader election lease is not ready.
+func (c *Controller) Ready() bool {
+	return atomic.LoadInt32(&c.ready) == 1
+}
+
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
 	}
 }
 
-func (c *Controller) purgeBlock(block *v1alpha1.Block) {
-	c.clientset.Block(block.Namespace).Delete(block.Name, &metav1.DeleteOptions{})
+// updateBlock writes the mined fields of block back to the API server,
+// retrying on conflicts with concurrent writers.
+func (c *Controller) updateBlock(block *v1alpha1.Block) error {
+	client := c.clientset.Block(block.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := client.Get(block.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		current.Spec.Timestamp = block.Spec.Timestamp
+		current.Spec.PrevBlockHash = block.Spec.PrevBlockHash
+		current.Spec.Hash = block.Spec.Hash
+		current.Spec.Nonce = block.Spec.Nonce
+		current.Spec.Difficulty = block.Spec.Difficulty
+		current.Spec.Transactions = block.Spec.Transactions
+		current.Spec.MerkleRoot = block.Spec.MerkleRoot
+		current.Spec.Signer = block.Spec.Signer
+		current.Spec.Signature = block.Spec.Signature
+		// The block is sealed, it is only deleted once the controller lets it go.
+		if !hasFinalizer(current) && current.DeletionTimestamp == nil {
+			current.Finalizers = append(current.Finalizers, v1alpha1.BlockFinalizer)
+		}
+		_, err = client.Update(current)
+		return err
+	})
+}
+
+// updateBlockStatus applies mutate to the latest status of block and writes it
+// through the status subresource, retrying on conflicts.
+func (c *Controller) updateBlockStatus(block *v1alpha1.Block, mutate func(status *v1alpha1.BlockStatus)) error {
+	client := c.clientset.Block(block.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := client.Get(block.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		mutate(&current.Status)
+		current.Status.ObservedGeneration = current.Generation
+		updated, err := client.UpdateStatus(current)
+		if err != nil {
+			return err
+		}
+		block.Status = updated.Status
+		return nil
+	})
 }
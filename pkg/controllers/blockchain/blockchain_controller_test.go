@@ -0,0 +1,78 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blockchain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nimrodshn/kubechain/pkg/chain"
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestQueuedOnAdd(t *testing.T) {
+	now := metav1.Now()
+	tests := []struct {
+		name     string
+		phase    v1alpha1.BlockPhase
+		deleted  *metav1.Time
+		expected bool
+	}{
+		{"new", "", nil, true},
+		{"pending", v1alpha1.BlockPending, nil, true},
+		{"mining", v1alpha1.BlockMining, nil, true},
+		{"orphan", v1alpha1.BlockOrphan, nil, true},
+		{"mined", v1alpha1.BlockMined, nil, false},
+		{"stale", v1alpha1.BlockStale, nil, false},
+		{"failed", v1alpha1.BlockFailed, nil, false},
+		{"deleted mined", v1alpha1.BlockMined, &now, true},
+		{"deleted failed", v1alpha1.BlockFailed, &now, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			block := &v1alpha1.Block{Status: v1alpha1.BlockStatus{Phase: test.phase}}
+			block.DeletionTimestamp = test.deleted
+			if queued := queuedOnAdd(block); queued != test.expected {
+				t.Fatalf("expected queued %t, got %t", test.expected, queued)
+			}
+		})
+	}
+}
+
+// TestFailedBlockIsNotMined checks a failed block which kept its seal, e.g. rejected
+// when its blockchain was recovered, is left alone rather than prepared and mined again.
+// The controller has no client, any call to the API server would panic.
+func TestFailedBlockIsNotMined(t *testing.T) {
+	block := &v1alpha1.Block{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "rejected"},
+		Spec:       v1alpha1.BlockSpec{Hash: []byte("hash"), PrevBlockHash: []byte("parent")},
+		Status:     v1alpha1.BlockStatus{Phase: v1alpha1.BlockFailed},
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err := indexer.Add(block); err != nil {
+		t.Fatal(err)
+	}
+	c := &Controller{chains: chain.NewManager()}
+	if err := c.addBlockEventHandler(context.Background(), "ns/rejected", indexer); err != nil {
+		t.Fatal(err)
+	}
+	if keys := c.chains.Keys(); len(keys) != 0 {
+		t.Fatalf("expected no blockchain to be tracked, got %v", keys)
+	}
+}
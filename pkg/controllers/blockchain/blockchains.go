@@ -0,0 +1,130 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blockchain
+
+import (
+	"github.com/nimrodshn/kubechain/pkg/consensus"
+	"github.com/nimrodshn/kubechain/pkg/ledger"
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+
+	"fmt"
+)
+
+// ensureBlockchain makes sure the named blockchain is tracked by the chain
+// manager and returns its key. The default blockchain is created on first use.
+func (c *Controller) ensureBlockchain(namespace, name string) (string, error) {
+	key := namespace + "/" + name
+	if c.chains.IsTracked(key) {
+		return key, nil
+	}
+
+	item, exists, err := c.chainInformer.GetIndexer().GetByKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	var blockchain *v1alpha1.Blockchain
+	switch {
+	case exists:
+		cached, ok := item.(*v1alpha1.Blockchain)
+		if !ok {
+			return "", fmt.Errorf("An error occured! expected a resource of type blockchain instead got %T", item)
+		}
+		blockchain = cached.DeepCopy()
+	case name == v1alpha1.DefaultChainName:
+		blockchain, err = c.createDefaultBlockchain(namespace)
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("Blockchain %s does not exist", key)
+	}
+
+	engine, err := consensus.New(blockchain, c.consensusConfig)
+	if err != nil {
+		return "", err
+	}
+	genesis, err := ledger.New(blockchain, c.ledgerConfig)
+	if err != nil {
+		return "", err
+	}
+	c.chains.Track(key, blockchain, engine, genesis)
+	return key, nil
+}
+
+// getBlockchain returns the blockchain with the given key from the informer cache.
+func (c *Controller) getBlockchain(key string) (*v1alpha1.Blockchain, error) {
+	item, exists, err := c.chainInformer.GetIndexer().GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("Blockchain %s does not exist", key)
+	}
+	blockchain, ok := item.(*v1alpha1.Blockchain)
+	if !ok {
+		return nil, fmt.Errorf("An error occured! expected a resource of type blockchain instead got %T", item)
+	}
+	return blockchain, nil
+}
+
+// createDefaultBlockchain creates the default blockchain in the given namespace,
+// or returns the existing one.
+func (c *Controller) createDefaultBlockchain(namespace string) (*v1alpha1.Blockchain, error) {
+	client := c.clientset.Blockchain(namespace)
+	blockchain, err := client.Create(&v1alpha1.Blockchain{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      v1alpha1.DefaultChainName,
+			Namespace: namespace,
+		},
+	})
+	if errors.IsAlreadyExists(err) {
+		return client.Get(v1alpha1.DefaultChainName, metav1.GetOptions{})
+	}
+	return blockchain, err
+}
+
+// updateBlockchainStatus writes the status of the in-memory blockchain
+// through the status subresource, retrying on conflicts.
+func (c *Controller) updateBlockchainStatus(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	client := c.clientset.Blockchain(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := client.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		// Compute the status on every attempt so the latest tip is written.
+		status, err := c.chains.Status(key)
+		if err != nil {
+			return err
+		}
+		// Tombstones are recorded as blocks are pruned, see finalizeBlock.
+		status.Tombstones = current.Status.Tombstones
+		current.Status = status
+		current.Status.ObservedGeneration = current.Generation
+		_, err = client.UpdateStatus(current)
+		return err
+	})
+}
@@ -0,0 +1,160 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blockchain
+
+import (
+	"github.com/golang/glog"
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+
+	"fmt"
+)
+
+// Reasons of the events recorded when sealed blocks are deleted.
+const (
+	reasonDeletionBlocked = "DeletionBlocked"
+	reasonPruned          = "Pruned"
+)
+
+// hasFinalizer reports whether the block carries v1alpha1.BlockFinalizer.
+func hasFinalizer(block *v1alpha1.Block) bool {
+	for _, finalizer := range block.Finalizers {
+		if finalizer == v1alpha1.BlockFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// protectBlock puts v1alpha1.BlockFinalizer on a sealed block, so deleting it does not
+// silently break the blockchain held in memory.
+func (c *Controller) protectBlock(block *v1alpha1.Block) error {
+	client := c.clientset.Block(block.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := client.Get(block.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		// Finalizers cannot be added to blocks which are being deleted.
+		if hasFinalizer(current) || current.DeletionTimestamp != nil {
+			return nil
+		}
+		current.Finalizers = append(current.Finalizers, v1alpha1.BlockFinalizer)
+		_, err = client.Update(current)
+		return err
+	})
+}
+
+// finalizeBlock handles the deletion of a sealed block. Unless its blockchain allows
+// pruning the finalizer is kept and the block stays in the blockchain, otherwise its
+// tombstone is recorded in the status of the blockchain before it is let go.
+// The block itself stays in the blockchain held in memory.
+func (c *Controller) finalizeBlock(key, chainKey string, block *v1alpha1.Block) error {
+	if !hasFinalizer(block) {
+		return nil
+	}
+	blockchain, err := c.getBlockchain(chainKey)
+	if err != nil {
+		return err
+	}
+	if !blockchain.AllowsPruning() {
+		glog.Warningf("Not deleting block %s, blockchain %s does not allow pruning", key, chainKey)
+		c.recorder.Eventf(block, corev1.EventTypeWarning, reasonDeletionBlocked,
+			"Blockchain %s does not allow pruning its blocks, the block is kept", blockchain.Name)
+		return nil
+	}
+
+	deleter := block.Annotations[v1alpha1.DeletedByAnnotation]
+	if deleter == "" {
+		deleter = "unknown"
+	}
+	if err := c.recordTombstone(chainKey, v1alpha1.NewBlockTombstone(block, deleter)); err != nil {
+		return fmt.Errorf("failed to record the tombstone of block %s: %v", key, err)
+	}
+	if err := c.releaseBlock(block); err != nil {
+		return err
+	}
+	glog.Infof("Pruned block %s at height %d of blockchain %s, deleted by %s", key, block.Status.Height, chainKey, deleter)
+	c.recorder.Eventf(blockchain, corev1.EventTypeNormal, reasonPruned,
+		"Block %s at height %d with hash %x was deleted by %s", block.Name, block.Status.Height, block.Spec.Hash, deleter)
+	return nil
+}
+
+// recordTombstone adds tombstone to the status of the blockchain, unless it is already there.
+func (c *Controller) recordTombstone(chainKey string, tombstone v1alpha1.BlockTombstone) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(chainKey)
+	if err != nil {
+		return err
+	}
+	client := c.clientset.Blockchain(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := client.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if current.Tombstone(tombstone.Hash) != nil {
+			return nil
+		}
+		current.Status.Tombstones = append(current.Status.Tombstones, tombstone)
+		_, err = client.UpdateStatus(current)
+		return err
+	})
+}
+
+// releaseBlock removes v1alpha1.BlockFinalizer from a block, letting the API server delete it.
+func (c *Controller) releaseBlock(block *v1alpha1.Block) error {
+	client := c.clientset.Block(block.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := client.Get(block.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) || (err == nil && current.UID != block.UID) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		finalizers := current.Finalizers[:0]
+		for _, finalizer := range current.Finalizers {
+			if finalizer != v1alpha1.BlockFinalizer {
+				finalizers = append(finalizers, finalizer)
+			}
+		}
+		current.Finalizers = finalizers
+		_, err = client.Update(current)
+		return err
+	})
+}
+
+// purgeBlock deletes a block the controller gave up on, e.g. because it could not be
+// mined in time. Sealed blocks are never purged, and the precondition makes sure a
+// block created again under the same name is not deleted in its place.
+func (c *Controller) purgeBlock(block *v1alpha1.Block) {
+	if hasFinalizer(block) {
+		glog.Warningf("Not purging sealed block %s/%s", block.Namespace, block.Name)
+		return
+	}
+	uid := types.UID(block.UID)
+	err := c.clientset.Block(block.Namespace).Delete(block.Name, &metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &uid},
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		glog.Errorf("Failed to purge block %s/%s: %v", block.Namespace, block.Name, err)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blockchain
+
+import (
+	"github.com/golang/glog"
+	"github.com/nimrodshn/kubechain/pkg/consensus"
+	"github.com/nimrodshn/kubechain/pkg/ledger"
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+
+	"fmt"
+)
+
+// connectBlock connects a block which was submitted already sealed to the block
+// tree of its blockchain, reorganizing the main chain if the branch of the block
+// ends up with the most work.
+func (c *Controller) connectBlock(key, chainKey string, block *v1alpha1.Block) error {
+	reorg, err := c.connect(chainKey, block)
+	if err == v1alpha1.ErrUnknownParent {
+		return c.holdOrphan(key, chainKey, block)
+	} else if consensus.IsInvalidBlock(err) || ledger.IsInvalidTransaction(err) {
+		glog.Warningf("Rejecting invalid block %s: %v", key, err)
+		return c.updateBlockStatus(block, func(status *v1alpha1.BlockStatus) {
+			status.Phase = v1alpha1.BlockFailed
+			status.SetCondition(v1alpha1.BlockCondition{
+				Type:    v1alpha1.BlockConditionAppended,
+				Status:  v1alpha1.ConditionFalse,
+				Reason:  v1alpha1.ReasonInvalidBlock,
+				Message: err.Error(),
+			})
+		})
+	} else if err != nil {
+		return err
+	}
+
+	changed := []*v1alpha1.Block{block}
+	if reorg != nil {
+		changed = append(changed, reorg.Disconnected...)
+		changed = append(changed, reorg.Connected...)
+		if reorg.Depth() > 0 {
+			c.recordReorg(chainKey, reorg)
+		}
+	}
+	c.syncBlockStatuses(chainKey, changed)
+	c.adoptOrphans(chainKey, block.Spec.Hash)
+	return c.updateBlockchainStatus(chainKey)
+}
+
+// connect connects a sealed block to its blockchain, through the replicator if there is one.
+func (c *Controller) connect(key string, block *v1alpha1.Block) (*v1alpha1.Reorg, error) {
+	if c.replicator != nil {
+		return c.replicator.Connect(key, block)
+	}
+	return c.chains.Connect(key, block)
+}
+
+// syncBlockStatuses marks the given blocks of a blockchain as mined at their height
+// when they are on its main chain, or as stale when they are on another branch.
+func (c *Controller) syncBlockStatuses(chainKey string, blocks []*v1alpha1.Block) {
+	synced := make(map[string]bool)
+	for _, block := range blocks {
+		key, err := cache.MetaNamespaceKeyFunc(block)
+		if err != nil || synced[key] {
+			continue
+		}
+		synced[key] = true
+
+		// Compare against the latest known status rather than the one of the copy.
+		item, exists, err := c.informer.GetIndexer().GetByKey(key)
+		if err != nil || !exists {
+			continue
+		}
+		cached, ok := item.(*v1alpha1.Block)
+		if !ok {
+			continue
+		}
+		if c.chains.Knows(chainKey, block.Spec.Hash) && !hasFinalizer(cached) && cached.DeletionTimestamp == nil {
+			if err := c.protectBlock(block); err != nil {
+				glog.Errorf("Failed to add the finalizer of block %s: %v", key, err)
+			}
+		}
+
+		if height, ok := c.chains.Height(chainKey, block.Spec.Hash); ok {
+			if cached.Status.Phase == v1alpha1.BlockMined && cached.Status.Height == height {
+				continue
+			}
+			err = c.updateBlockStatus(block, func(status *v1alpha1.BlockStatus) {
+				status.Phase = v1alpha1.BlockMined
+				status.Height = height
+				status.SetCondition(v1alpha1.BlockCondition{
+					Type:    v1alpha1.BlockConditionAppended,
+					Status:  v1alpha1.ConditionTrue,
+					Reason:  v1alpha1.ReasonAppendedToChain,
+					Message: fmt.Sprintf("Appended at height %d", height),
+				})
+			})
+		} else if c.chains.Knows(chainKey, block.Spec.Hash) {
+			if cached.Status.Phase == v1alpha1.BlockStale {
+				continue
+			}
+			reason, message := v1alpha1.ReasonForkChoiceLost, "The block is on a branch with less work than the main chain"
+			if cached.Status.Phase == v1alpha1.BlockMined {
+				reason, message = v1alpha1.ReasonReorganized, "The block was disconnected from the main chain by a reorganization"
+			}
+			err = c.updateBlockStatus(block, func(status *v1alpha1.BlockStatus) {
+				status.Phase = v1alpha1.BlockStale
+				status.SetCondition(v1alpha1.BlockCondition{
+					Type:    v1alpha1.BlockConditionAppended,
+					Status:  v1alpha1.ConditionFalse,
+					Reason:  reason,
+					Message: message,
+				})
+			})
+		}
+		if err != nil {
+			glog.Errorf("Failed to update status of block %s: %v", key, err)
+		}
+	}
+}
+
+// recordReorg emits an event on the blockchain describing a reorganization of its main chain.
+func (c *Controller) recordReorg(chainKey string, reorg *v1alpha1.Reorg) {
+	item, exists, err := c.chainInformer.GetIndexer().GetByKey(chainKey)
+	if err != nil || !exists {
+		return
+	}
+	blockchain, ok := item.(k8sruntime.Object)
+	if !ok {
+		return
+	}
+	tip := reorg.Connected[len(reorg.Connected)-1]
+	c.recorder.Eventf(blockchain, corev1.EventTypeNormal, v1alpha1.ReasonReorganized,
+		"Reorganized to tip %x at height %d, %d blocks disconnected and %d blocks connected at height %d",
+		tip.Spec.Hash, reorg.ForkHeight+int64(len(reorg.Connected))-1, reorg.Depth(), len(reorg.Connected), reorg.ForkHeight)
+}
@@ -0,0 +1,96 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blockchain
+
+import (
+	"github.com/golang/glog"
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+
+	"fmt"
+	"time"
+)
+
+// How often orphans are checked for expiry.
+const orphanExpiryInterval = time.Minute
+
+// holdOrphan holds a sealed block whose parent is not known in the orphan pool
+// until the parent is connected.
+func (c *Controller) holdOrphan(key, chainKey string, block *v1alpha1.Block) error {
+	glog.Infof("The parent %x of block %s is not known, holding it as an orphan", block.Spec.PrevBlockHash, key)
+	for _, evicted := range c.orphans.Add(key, chainKey, block) {
+		c.failOrphan(evicted, v1alpha1.ReasonOrphanEvicted, "The orphan pool is full")
+	}
+	if block.Status.Phase != v1alpha1.BlockOrphan {
+		err := c.updateBlockStatus(block, func(status *v1alpha1.BlockStatus) {
+			status.Phase = v1alpha1.BlockOrphan
+			status.SetCondition(v1alpha1.BlockCondition{
+				Type:    v1alpha1.BlockConditionAppended,
+				Status:  v1alpha1.ConditionFalse,
+				Reason:  v1alpha1.ReasonParentUnknown,
+				Message: fmt.Sprintf("Waiting for the parent block %x", block.Spec.PrevBlockHash),
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	// The parent may have been connected since the block was found to be an orphan.
+	if c.chains.Knows(chainKey, block.Spec.PrevBlockHash) {
+		c.adoptOrphans(chainKey, block.Spec.PrevBlockHash)
+	}
+	return nil
+}
+
+// adoptOrphans queues the orphans waiting for the block with the given hash,
+// which was just connected, so they are connected in turn.
+func (c *Controller) adoptOrphans(chainKey string, hash []byte) {
+	for _, key := range c.orphans.Adopt(chainKey, hash) {
+		glog.Infof("The parent of orphan block %s was connected, queueing it", key)
+		c.queue.Add(key)
+	}
+}
+
+// expireOrphans fails the orphans whose parent did not arrive in time.
+func (c *Controller) expireOrphans() {
+	for _, key := range c.orphans.Expire() {
+		c.failOrphan(key, v1alpha1.ReasonOrphanExpired, "The parent block did not arrive in time")
+	}
+}
+
+// failOrphan marks an orphan which was dropped from the orphan pool as failed.
+func (c *Controller) failOrphan(key, reason, message string) {
+	item, exists, err := c.informer.GetIndexer().GetByKey(key)
+	if err != nil || !exists {
+		return
+	}
+	block, ok := item.(*v1alpha1.Block)
+	if !ok {
+		return
+	}
+	glog.Infof("Dropping orphan block %s: %s", key, message)
+	err = c.updateBlockStatus(block.DeepCopy(), func(status *v1alpha1.BlockStatus) {
+		status.Phase = v1alpha1.BlockFailed
+		status.SetCondition(v1alpha1.BlockCondition{
+			Type:    v1alpha1.BlockConditionAppended,
+			Status:  v1alpha1.ConditionFalse,
+			Reason:  reason,
+			Message: message,
+		})
+	})
+	if err != nil {
+		glog.Errorf("Failed to update status of block %s: %v", key, err)
+	}
+}
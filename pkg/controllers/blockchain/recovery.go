@@ -0,0 +1,142 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blockchain
+
+import (
+	"github.com/golang/glog"
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+// recoverBlockchains rebuilds the in-memory blockchains from the blocks which were
+// already sealed and persisted in the API server, so a restarted controller neither
+// re-mines them nor depends on the list order: the branch with the most work becomes
+// the main chain again. Sealed blocks are never mined again, which would rewrite the
+// history they were part of: those whose parent is not known are held as orphans and
+// those which fail validation are marked as failed. Pruned blocks are recovered from their
+// tombstones, so the blocks after them still link to the chain. With a replicator the
+// leader recovers the blockchains the raft log does not hold blocks of yet.
+func (c *Controller) recoverBlockchains() {
+	sealed := make(map[string][]*v1alpha1.Block)
+	for _, item := range c.informer.GetIndexer().List() {
+		block, ok := item.(*v1alpha1.Block)
+		if !ok || (block.Status.Phase != v1alpha1.BlockMined && block.Status.Phase != v1alpha1.BlockStale) {
+			continue
+		}
+		key := block.Namespace + "/" + block.ChainName()
+		sealed[key] = append(sealed[key], block.DeepCopy())
+	}
+	pruned := make(map[string][]*v1alpha1.Block)
+	for _, item := range c.chainInformer.GetIndexer().List() {
+		blockchain, ok := item.(*v1alpha1.Blockchain)
+		if !ok || len(blockchain.Status.Tombstones) == 0 {
+			continue
+		}
+		key := blockchain.Namespace + "/" + blockchain.Name
+		for i := range blockchain.Status.Tombstones {
+			pruned[key] = append(pruned[key], blockchain.Status.Tombstones[i].Block(blockchain.Namespace))
+		}
+		if _, ok := sealed[key]; !ok {
+			sealed[key] = nil
+		}
+	}
+
+	for key, blocks := range sealed {
+		namespace, name, err := cache.SplitMetaNamespaceKey(key)
+		if err != nil {
+			glog.Errorf("Failed to recover blockchain %s: %v", key, err)
+			continue
+		}
+		if _, err := c.ensureBlockchain(namespace, name); err != nil {
+			glog.Errorf("Failed to recover blockchain %s: %v", key, err)
+			continue
+		}
+
+		var rejected, unknown []*v1alpha1.Block
+		if _, height, _ := c.chains.Tip(key); c.replicator != nil && height > 0 {
+			// The replicated blockchain is authoritative, the sealed blocks
+			// it does not know are connected to it like submitted ones.
+			for _, block := range blocks {
+				if !c.chains.Knows(key, block.Spec.Hash) {
+					unknown = append(unknown, block)
+				}
+			}
+		} else {
+			// Only the blocks which pass validation (PoW, difficulty required
+			// at each height) and link to the other blocks are recovered.
+			recovered := make([]*v1alpha1.Block, 0, len(blocks)+len(pruned[key]))
+			recovered = append(append(recovered, blocks...), pruned[key]...)
+			rejected, err = c.resetBlockchain(key, recovered)
+			if err != nil {
+				glog.Errorf("Failed to recover blockchain %s: %v", key, err)
+				continue
+			}
+			glog.Infof("Recovered %d blocks of blockchain %s, of which %d pruned, %d blocks were rejected",
+				len(recovered)-len(rejected), key, len(pruned[key]), len(rejected))
+		}
+
+		// The main chain may differ from the one before the restart.
+		c.syncBlockStatuses(key, blocks)
+		if err := c.updateBlockchainStatus(key); err != nil {
+			glog.Errorf("Failed to update status of blockchain %s: %v", key, err)
+		}
+
+		for _, block := range rejected {
+			if block.Status.Phase == v1alpha1.BlockPruned {
+				glog.Warningf("Pruned block %x of blockchain %s cannot be recovered from its tombstone", block.Spec.Hash, key)
+				continue
+			}
+			c.rejectRecovered(key, block)
+		}
+		for _, block := range unknown {
+			blockKey, err := cache.MetaNamespaceKeyFunc(block)
+			if err != nil {
+				continue
+			}
+			if err := c.connectBlock(blockKey, key, block); err != nil {
+				glog.Errorf("Failed to connect block %s to blockchain %s: %v", blockKey, key, err)
+			}
+		}
+	}
+}
+
+// rejectRecovered holds a sealed block which could not be recovered as an orphan when
+// its parent is not known, or marks it as failed when it breaks the rules of its blockchain.
+func (c *Controller) rejectRecovered(chainKey string, block *v1alpha1.Block) {
+	key, err := cache.MetaNamespaceKeyFunc(block)
+	if err != nil {
+		glog.Errorf("Failed to reject block %s/%s: %v", block.Namespace, block.Name, err)
+		return
+	}
+	if len(block.Spec.PrevBlockHash) > 0 && !c.chains.Knows(chainKey, block.Spec.PrevBlockHash) {
+		err = c.holdOrphan(key, chainKey, block)
+	} else {
+		glog.Warningf("Rejecting block %s which failed validation while recovering blockchain %s", key, chainKey)
+		err = c.updateBlockStatus(block, func(status *v1alpha1.BlockStatus) {
+			status.Phase = v1alpha1.BlockFailed
+			status.SetCondition(v1alpha1.BlockCondition{
+				Type:    v1alpha1.BlockConditionAppended,
+				Status:  v1alpha1.ConditionFalse,
+				Reason:  v1alpha1.ReasonInvalidBlock,
+				Message: "The block failed validation when its blockchain was recovered",
+			})
+		})
+	}
+	if err != nil {
+		glog.Errorf("Failed to update status of block %s: %v", key, err)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blockchain
+
+import (
+	"github.com/golang/glog"
+	"github.com/nimrodshn/kubechain/pkg/chain"
+	"github.com/nimrodshn/kubechain/pkg/consensus"
+	"github.com/nimrodshn/kubechain/pkg/ledger"
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+
+	"k8s.io/client-go/tools/cache"
+
+	"reflect"
+)
+
+// Replicator orders the appends to the blockchains across controller replicas, see
+// replication.Raft. Only the leader mines blocks, every replica applies the blocks
+// committed by the leader to its chain manager.
+type Replicator interface {
+	// Start joins the cluster and applies the committed blocks to chains, track
+	// must make sure a blockchain is managed by chains with the spec it carries without
+	// doing any I/O. The blocks proposed for a blockchain carry the one blockchains returns.
+	Start(chains *chain.Manager, track func(blockchain *v1alpha1.Blockchain) error,
+		blockchains func(key string) (*v1alpha1.Blockchain, error)) error
+	// Append proposes a sealed block and returns its height once it is applied.
+	Append(key string, block *v1alpha1.Block) (int64, error)
+	// Connect proposes a sealed block anywhere in the block tree of a blockchain and returns
+	// the change of its main chain once it is applied.
+	Connect(key string, block *v1alpha1.Block) (*v1alpha1.Reorg, error)
+	// Reset proposes to replace the block tree of a blockchain and returns the blocks
+	// which could not be connected.
+	Reset(key string, blocks []*v1alpha1.Block) ([]*v1alpha1.Block, error)
+	// IsLeader reports whether the replica is the leader of the cluster.
+	IsLeader() bool
+	// LeaderChanges delivers true when the replica becomes the leader and false when it stops being it.
+	LeaderChanges() <-chan bool
+	// Shutdown leaves the cluster.
+	Shutdown() error
+}
+
+// leading reports whether this replica mines blocks, a controller without a replicator always does.
+func (c *Controller) leading() bool {
+	return c.replicator == nil || c.replicator.IsLeader()
+}
+
+// appendBlock appends a sealed block to its blockchain, through the replicator if there is one.
+// The blockchain must already be tracked, see ensureBlockchain.
+func (c *Controller) appendBlock(key string, block *v1alpha1.Block) (int64, error) {
+	if c.replicator != nil {
+		return c.replicator.Append(key, block)
+	}
+	return c.chains.Append(key, block)
+}
+
+// resetBlockchain replaces the block tree of a blockchain, through the replicator if there is one.
+func (c *Controller) resetBlockchain(key string, blocks []*v1alpha1.Block) ([]*v1alpha1.Block, error) {
+	if c.replicator != nil {
+		return c.replicator.Reset(key, blocks)
+	}
+	return c.chains.Reset(key, blocks)
+}
+
+// trackReplicated makes sure a blockchain replicated by the leader is tracked by the
+// chain manager with the spec carried by the raft log, so every replica verifies the
+// same blocks under the same spec. It is called while applying the raft log, so it
+// does no I/O: the engine of the blockchain cannot seal proof of authority blocks
+// until the key of its signer is loaded, see loadSigners.
+func (c *Controller) trackReplicated(blockchain *v1alpha1.Blockchain) error {
+	key := blockchain.Namespace + "/" + blockchain.Name
+	tracked := c.chains.IsTracked(key)
+	if tracked {
+		current, err := c.chains.Blockchain(key)
+		if err != nil {
+			return err
+		}
+		if reflect.DeepEqual(current.Spec, blockchain.Spec) {
+			return nil
+		}
+	}
+	engine, err := consensus.NewVerifier(blockchain, c.consensusConfig)
+	if err != nil {
+		return err
+	}
+	if !tracked {
+		genesis, err := ledger.New(blockchain, c.ledgerConfig)
+		if err != nil {
+			return err
+		}
+		c.chains.Track(key, blockchain, engine, genesis)
+		return nil
+	}
+	glog.Infof("Updating the spec of replicated blockchain %s", key)
+	if err := c.chains.UpdateSpec(key, blockchain.Spec, engine); err != nil {
+		return err
+	}
+	if c.leading() && blockchain.Spec.Consensus == v1alpha1.ConsensusProofOfAuthority {
+		go c.loadSigner(key)
+	}
+	return nil
+}
+
+// replicatedBlockchain returns the blockchain whose spec the blocks proposed for it
+// carry, as it is in the API server.
+func (c *Controller) replicatedBlockchain(key string) (*v1alpha1.Blockchain, error) {
+	blockchain, err := c.getBlockchain(key)
+	if err != nil {
+		// e.g. the blockchain is being deleted, its blocks keep its last spec.
+		return c.chains.Blockchain(key)
+	}
+	replicated := &v1alpha1.Blockchain{
+		TypeMeta:   blockchain.TypeMeta,
+		ObjectMeta: blockchain.ObjectMeta,
+		Spec:       blockchain.Spec,
+	}
+	return replicated.DeepCopy(), nil
+}
+
+// loadSigners gives the proof of authority blockchains tracked while following the
+// key of their signer, read from its Secret, so this replica can seal their blocks.
+func (c *Controller) loadSigners() {
+	for _, key := range c.chains.Keys() {
+		c.loadSigner(key)
+	}
+}
+
+// loadSigner gives a proof of authority blockchain the key of its signer. Only its
+// engine is replaced, its spec stays the one replicated through the raft log.
+func (c *Controller) loadSigner(key string) {
+	blockchain, err := c.chains.Blockchain(key)
+	if err != nil || blockchain.Spec.Consensus != v1alpha1.ConsensusProofOfAuthority {
+		return
+	}
+	engine, err := consensus.New(blockchain, c.consensusConfig)
+	if err != nil {
+		glog.Errorf("Failed to load the signer of blockchain %s: %v", key, err)
+		return
+	}
+	c.chains.UpdateEngine(key, engine)
+}
+
+// followLeadership takes over mining whenever this replica becomes the leader
+// and stops mining when it no longer is.
+func (c *Controller) followLeadership(stopCh <-chan struct{}) {
+	for {
+		select {
+		case leader := <-c.replicator.LeaderChanges():
+			if leader {
+				c.loadSigners()
+				c.recoverBlockchains()
+				c.completeReplicatedBlocks()
+				c.queuePendingBlocks()
+				c.queueTransactions()
+			} else {
+				c.cancelAllMining()
+				c.mempool.Clear()
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// completeReplicatedBlocks writes back the blocks which were committed to the replicated
+// blockchains but not persisted in the API server, e.g. because the previous leader
+// was lost right after appending them.
+func (c *Controller) completeReplicatedBlocks() {
+	for _, key := range c.chains.Keys() {
+		blocks, err := c.chains.Blocks(key)
+		if err != nil {
+			glog.Errorf("Failed to get the blocks of blockchain %s: %v", key, err)
+			continue
+		}
+		for height, block := range blocks {
+			item, exists, err := c.informer.GetIndexer().Get(block)
+			if err != nil || !exists {
+				continue
+			}
+			if cached, ok := item.(*v1alpha1.Block); ok && cached.Status.Phase == v1alpha1.BlockMined {
+				continue
+			}
+			glog.Infof("Writing back replicated block %s/%s", block.Namespace, block.Name)
+			if err := c.writeMinedBlock(block, int64(height)); err != nil {
+				glog.Errorf("Failed to write back replicated block %s/%s: %v", block.Namespace, block.Name, err)
+			}
+		}
+		if err := c.updateBlockchainStatus(key); err != nil {
+			glog.Errorf("Failed to update status of blockchain %s: %v", key, err)
+		}
+	}
+}
+
+// queuePendingBlocks queues the blocks which are neither mined nor failed, the
+// followers do not mine the blocks they are notified about so the new leader has to.
+func (c *Controller) queuePendingBlocks() {
+	replicated := make(map[string]bool)
+	for _, key := range c.chains.Keys() {
+		blocks, err := c.chains.Blocks(key)
+		if err != nil {
+			continue
+		}
+		for _, block := range blocks {
+			replicated[block.Namespace+"/"+block.Name] = true
+		}
+	}
+
+	for _, item := range c.informer.GetIndexer().List() {
+		block, ok := item.(*v1alpha1.Block)
+		if !ok || block.Status.Phase == v1alpha1.BlockMined || block.Status.Phase == v1alpha1.BlockFailed {
+			continue
+		}
+		key, err := cache.MetaNamespaceKeyFunc(block)
+		if err != nil || replicated[key] {
+			continue
+		}
+		c.queue.Add(key)
+	}
+}
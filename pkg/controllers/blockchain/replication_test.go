@@ -0,0 +1,47 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/nimrodshn/kubechain/pkg/chain"
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestTrackReplicatedSpec checks the spec of a replicated blockchain follows the spec
+// carried by the raft log entries, whatever the order they are applied in.
+func TestTrackReplicatedSpec(t *testing.T) {
+	c := &Controller{chains: chain.NewManager()}
+	for _, difficulty := range []int64{10, 12, 10} {
+		blockchain := &v1alpha1.Blockchain{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "c"},
+			Spec:       v1alpha1.BlockchainSpec{Difficulty: difficulty},
+		}
+		if err := c.trackReplicated(blockchain); err != nil {
+			t.Fatal(err)
+		}
+		tracked, err := c.chains.Blockchain("ns/c")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tracked.Spec.Difficulty != difficulty {
+			t.Fatalf("expected difficulty %d, got %d", difficulty, tracked.Spec.Difficulty)
+		}
+	}
+}
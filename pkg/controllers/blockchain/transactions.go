@@ -0,0 +1,407 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blockchain
+
+import (
+	"github.com/golang/glog"
+	"github.com/nimrodshn/kubechain/pkg/chain"
+	clientset "github.com/nimrodshn/kubechain/pkg/clientset/v1alpha1"
+	"github.com/nimrodshn/kubechain/pkg/ledger"
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// The name of the index of the transactions by the key of the block they were batched into.
+const blockIndex = "block"
+
+// BatchConfig configures how pending transactions are batched into blocks.
+type BatchConfig struct {
+	// MaxTransactions is the maximum number of transactions in a block, a block is
+	// produced as soon as as many transactions are pending for a blockchain.
+	MaxTransactions int
+	// Interval is how often a block is produced from the transactions pending for
+	// each blockchain, however few they are.
+	Interval time.Duration
+}
+
+// NewTransactionInformer Creates a new informer for the Transaction crd.
+func NewTransactionInformer(ns string, clientSet clientset.KubechainV1Alpha1Interface) cache.SharedIndexInformer {
+	informer := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(lo metav1.ListOptions) (result k8sruntime.Object, err error) {
+				return clientSet.Transaction(ns).List(lo)
+			},
+			WatchFunc: func(lo metav1.ListOptions) (watch.Interface, error) {
+				return clientSet.Transaction(ns).Watch(lo)
+			},
+		},
+		&v1alpha1.TransactionRequest{},
+		1*time.Minute,
+		cache.Indexers{blockIndex: indexByBlock},
+	)
+	return informer
+}
+
+// indexByBlock indexes transactions by the key of the block they were batched into.
+func indexByBlock(obj interface{}) ([]string, error) {
+	tr, ok := obj.(*v1alpha1.TransactionRequest)
+	if !ok || tr.Status.Block == "" {
+		return nil, nil
+	}
+	return []string{tr.Namespace + "/" + tr.Status.Block}, nil
+}
+
+// queueTransaction queues the transaction with the key of obj.
+func (c *Controller) queueTransaction(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	c.txQueue.Add(key)
+}
+
+// queueBlockTransactions queues the transactions batched into the given block, e.g.
+// when it is mined, so their status follows the block.
+func (c *Controller) queueBlockTransactions(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	keys, err := c.txInformer.GetIndexer().IndexKeys(blockIndex, key)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, key := range keys {
+		c.txQueue.Add(key)
+	}
+}
+
+// queueTransactions queues every transaction, e.g. when the replica becomes the
+// leader and has to rebuild its mempool.
+func (c *Controller) queueTransactions() {
+	for _, key := range c.txInformer.GetIndexer().ListKeys() {
+		c.txQueue.Add(key)
+	}
+}
+
+func (c *Controller) processNextTransaction() bool {
+	key, quit := c.txQueue.Get()
+	if quit {
+		return false
+	}
+	defer c.txQueue.Done(key)
+
+	err := c.syncTransaction(key.(string))
+	if err == nil {
+		c.txQueue.Forget(key)
+		return true
+	}
+
+	if c.txQueue.NumRequeues(key) < maxRetries {
+		glog.Infof("Error processing transaction %v, retrying: %v", key, err)
+		c.txQueue.AddRateLimited(key)
+		return true
+	}
+
+	c.txQueue.Forget(key)
+	runtime.HandleError(fmt.Errorf("dropping transaction %q out of the queue: %v", key, err))
+	return true
+}
+
+func (c *Controller) runTransactionWorker() {
+	for c.processNextTransaction() {
+	}
+}
+
+// syncTransaction holds a pending transaction in the mempool, or makes the status of a
+// batched transaction follow the block it was batched into.
+func (c *Controller) syncTransaction(key string) error {
+	item, exists, err := c.txInformer.GetIndexer().GetByKey(key)
+	if err != nil {
+		return err
+	} else if !exists {
+		c.mempool.Remove(key)
+		return nil
+	}
+	cached, ok := item.(*v1alpha1.TransactionRequest)
+	if !ok {
+		return fmt.Errorf("An error occured! expected a resource of type transaction instead got %T", item)
+	}
+	// Only the leader batches transactions, it rebuilds its mempool when it takes over.
+	if !c.leading() {
+		return nil
+	}
+	// The status of the transaction is being written by the block producer.
+	if c.mempool.InFlight(key) {
+		return nil
+	}
+
+	tr := cached.DeepCopy()
+	switch tr.Status.Phase {
+	case "", v1alpha1.TransactionPending:
+		return c.admitTransaction(key, tr)
+	case v1alpha1.TransactionBatched, v1alpha1.TransactionIncluded:
+		return c.followBlock(tr)
+	}
+	return nil
+}
+
+// admitTransaction validates a pending transaction and holds it in the mempool, a
+// block is produced right away when enough transactions are pending.
+func (c *Controller) admitTransaction(key string, tr *v1alpha1.TransactionRequest) error {
+	if err := c.validateTransaction(tr); err != nil {
+		glog.Warningf("Rejecting invalid transaction %s: %v", key, err)
+		return c.updateTransactionStatus(tr, func(status *v1alpha1.TransactionRequestStatus) {
+			status.Phase = v1alpha1.TransactionFailed
+			status.Message = err.Error()
+		})
+	}
+	chainKey, err := c.ensureBlockchain(tr.Namespace, tr.ChainName())
+	if err != nil {
+		return err
+	}
+	// Reject transactions spending outputs which are spent, or are about to be by
+	// the transactions already in the mempool.
+	tx := tr.Transaction()
+	err = c.chains.ValidateTransaction(chainKey, &tx, c.mempool.Pending(chainKey, key))
+	if ledger.IsInvalidTransaction(err) {
+		glog.Warningf("Rejecting transaction %s: %v", key, err)
+		return c.updateTransactionStatus(tr, func(status *v1alpha1.TransactionRequestStatus) {
+			status.Phase = v1alpha1.TransactionFailed
+			status.Message = err.Error()
+		})
+	} else if err != nil {
+		return err
+	}
+	if tr.Status.Phase == "" {
+		err := c.updateTransactionStatus(tr, func(status *v1alpha1.TransactionRequestStatus) {
+			status.Phase = v1alpha1.TransactionPending
+			status.Message = "Waiting in the mempool"
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if c.mempool.Add(key, chainKey, tx) >= c.batching.MaxTransactions {
+		c.produceBlock(chainKey)
+	}
+	return nil
+}
+
+// validateTransaction checks a transaction can be batched into a block.
+func (c *Controller) validateTransaction(tr *v1alpha1.TransactionRequest) error {
+	if tr.Spec.Data == "" && len(tr.Spec.Inputs) == 0 && len(tr.Spec.Outputs) == 0 && tr.Spec.Transfer == nil {
+		return fmt.Errorf("the transaction has neither data, outputs nor a transfer")
+	}
+	if len(tr.Spec.Data) > v1alpha1.MaxDataSize {
+		return fmt.Errorf("the data of the transaction exceeds %d bytes", v1alpha1.MaxDataSize)
+	}
+	if tr.ChainName() == v1alpha1.DefaultChainName {
+		return nil
+	}
+	_, exists, err := c.chainInformer.GetIndexer().GetByKey(tr.Namespace + "/" + tr.ChainName())
+	if err == nil && !exists {
+		return fmt.Errorf("blockchain %s does not exist", tr.ChainName())
+	}
+	return nil
+}
+
+// followBlock updates the status of a batched transaction from the block it was batched
+// into. The transaction goes back to the mempool when the block fails, is deleted or
+// ends up on a stale branch, so it can be batched into another block.
+func (c *Controller) followBlock(tr *v1alpha1.TransactionRequest) error {
+	block, err := c.getBlock(tr.Namespace, tr.Status.Block)
+	if errors.IsNotFound(err) {
+		return c.resubmitTransaction(tr, fmt.Sprintf("Block %s was deleted", tr.Status.Block))
+	} else if err != nil {
+		return err
+	}
+
+	switch block.Status.Phase {
+	case v1alpha1.BlockMined:
+		if tr.Status.Phase == v1alpha1.TransactionIncluded && tr.Status.Height == block.Status.Height {
+			return nil
+		}
+		// Ledger chains drop the transactions which conflict with the ones mined before.
+		if _, err := block.TransactionIndex(hex.EncodeToString(tr.Status.ID)); err != nil {
+			return c.updateTransactionStatus(tr, func(status *v1alpha1.TransactionRequestStatus) {
+				status.Phase = v1alpha1.TransactionFailed
+				status.Message = fmt.Sprintf("Dropped from block %s, it spends outputs which are already spent", block.Name)
+			})
+		}
+		return c.updateTransactionStatus(tr, func(status *v1alpha1.TransactionRequestStatus) {
+			status.Phase = v1alpha1.TransactionIncluded
+			status.Height = block.Status.Height
+			status.Message = fmt.Sprintf("Included in block %s at height %d", block.Name, block.Status.Height)
+		})
+	case v1alpha1.BlockFailed:
+		return c.resubmitTransaction(tr, fmt.Sprintf("Block %s failed", block.Name))
+	case v1alpha1.BlockStale:
+		return c.resubmitTransaction(tr, fmt.Sprintf("Block %s is no longer part of the blockchain", block.Name))
+	}
+	return nil
+}
+
+// getBlock returns the block with the given name from the cache, or from the API server
+// when the cache did not see it yet, e.g. right after it was produced.
+func (c *Controller) getBlock(namespace, name string) (*v1alpha1.Block, error) {
+	item, exists, err := c.informer.GetIndexer().GetByKey(namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if block, ok := item.(*v1alpha1.Block); ok {
+			return block, nil
+		}
+	}
+	return c.clientset.Block(namespace).Get(name, metav1.GetOptions{})
+}
+
+// resubmitTransaction puts a batched transaction back in the pending phase, the
+// update queues it again so it is held in the mempool.
+func (c *Controller) resubmitTransaction(tr *v1alpha1.TransactionRequest, message string) error {
+	glog.Infof("Resubmitting transaction %s/%s: %s", tr.Namespace, tr.Name, message)
+	return c.updateTransactionStatus(tr, func(status *v1alpha1.TransactionRequestStatus) {
+		status.Phase = v1alpha1.TransactionPending
+		status.Block = ""
+		status.Height = 0
+		status.ID = nil
+		status.Message = message
+	})
+}
+
+// produceBlocks produces a block from the transactions pending for every blockchain.
+func (c *Controller) produceBlocks() {
+	if !c.leading() {
+		return
+	}
+	for _, chainKey := range c.mempool.Chains() {
+		c.produceBlock(chainKey)
+	}
+}
+
+// produceBlock batches the oldest transactions pending for a blockchain into a new
+// block, which is then mined like any other block. The transactions are marked as
+// batched into the block before it is created and put back in the mempool if it
+// cannot be.
+func (c *Controller) produceBlock(chainKey string) {
+	batch := c.mempool.Take(chainKey, c.batching.MaxTransactions)
+	if len(batch) == 0 {
+		return
+	}
+	defer c.mempool.Done(batch)
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(chainKey)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	block := &v1alpha1.Block{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + "-" + utilrand.String(5),
+			Namespace: namespace,
+		},
+		Spec: v1alpha1.BlockSpec{Chain: name},
+	}
+
+	// Mark the transactions as batched before creating the block, so no block is ever
+	// created with transactions which are still pending and could be batched again.
+	// If the controller stops before the block is created, the transactions follow a
+	// block which does not exist and are put back in the mempool, see followBlock.
+	var marked []*v1alpha1.TransactionRequest
+	var kept []*chain.PendingTransaction
+	for i, tx := range batch {
+		tr := &v1alpha1.TransactionRequest{}
+		tr.Namespace, tr.Name, err = cache.SplitMetaNamespaceKey(tx.Key)
+		if err != nil {
+			continue
+		}
+		err = c.updateTransactionStatus(tr, func(status *v1alpha1.TransactionRequestStatus) {
+			status.Phase = v1alpha1.TransactionBatched
+			status.Block = block.Name
+			status.Height = 0
+			status.ID = tx.Transaction.ID
+			status.Message = fmt.Sprintf("Batched into block %s", block.Name)
+		})
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			glog.Errorf("Failed to update status of transaction %s: %v", tx.Key, err)
+			c.unbatchTransactions(chainKey, append(kept, batch[i:]...), marked, "Failed to batch the transaction")
+			return
+		}
+		marked = append(marked, tr)
+		kept = append(kept, tx)
+		block.Spec.Transactions = append(block.Spec.Transactions, tx.Transaction)
+	}
+	if len(marked) == 0 {
+		return
+	}
+
+	if _, err := c.clientset.Block(namespace).Create(block); err != nil {
+		runtime.HandleError(fmt.Errorf("failed to produce a block for blockchain %s: %v", chainKey, err))
+		c.unbatchTransactions(chainKey, kept, marked, fmt.Sprintf("Failed to create block %s", block.Name))
+		return
+	}
+	glog.Infof("Batched %d transactions into block %s/%s", len(marked), namespace, block.Name)
+}
+
+// unbatchTransactions puts transactions back in the mempool when the block they were
+// batched into could not be created, marked are those whose status already names the block.
+func (c *Controller) unbatchTransactions(chainKey string, batch []*chain.PendingTransaction, marked []*v1alpha1.TransactionRequest, message string) {
+	for _, tr := range marked {
+		if err := c.resubmitTransaction(tr, message); err != nil && !errors.IsNotFound(err) {
+			glog.Errorf("Failed to update status of transaction %s/%s: %v", tr.Namespace, tr.Name, err)
+		}
+	}
+	// The updates above are not synced while the batch is in flight.
+	c.mempool.Return(chainKey, batch)
+}
+
+// updateTransactionStatus applies mutate to the latest status of a transaction and
+// writes it through the status subresource, retrying on conflicts.
+func (c *Controller) updateTransactionStatus(tr *v1alpha1.TransactionRequest, mutate func(status *v1alpha1.TransactionRequestStatus)) error {
+	client := c.clientset.Transaction(tr.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := client.Get(tr.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		mutate(&current.Status)
+		current.Status.ObservedGeneration = current.Generation
+		updated, err := client.UpdateStatus(current)
+		if err != nil {
+			return err
+		}
+		tr.Status = updated.Status
+		return nil
+	})
+}
@@ -0,0 +1,204 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ledger
+
+import (
+	"sort"
+
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+	"github.com/nimrodshn/kubechain/pkg/wallet"
+)
+
+// Accounts is the set of the accounts of a blockchain with their balance and nonce.
+// Transactions transfer value from the account of the wallet signing them to another
+// account, the coinbase, the first transaction of a block, credits the reward of the
+// block to its outputs. Each transfer carries the nonce of the account it is sent from,
+// which is incremented when it is applied, so a transfer cannot be replayed.
+type Accounts struct {
+	reward        int64
+	rewardAddress string
+	accounts      map[string]v1alpha1.AccountStatus
+}
+
+// NewAccounts is a constructor for a ledger without accounts paying reward for each
+// block, to rewardAddress for the blocks prepared on top of it.
+func NewAccounts(reward int64, rewardAddress string) *Accounts {
+	return &Accounts{
+		reward:        reward,
+		rewardAddress: rewardAddress,
+		accounts:      make(map[string]v1alpha1.AccountStatus),
+	}
+}
+
+// Prepare drops the transactions of block which cannot be applied, e.g. transfers
+// exceeding the balance of their account, and adds the coinbase in front of the others.
+func (a *Accounts) Prepare(block *v1alpha1.Block, height int64) {
+	prepare(block, height, a.rewardAddress, a.reward, a.clone().apply)
+}
+
+// Apply applies the transfers and the coinbase of block to the accounts.
+func (a *Accounts) Apply(block *v1alpha1.Block, height int64) error {
+	state := a.clone()
+	transactions := block.Transactions()
+	for i := range transactions {
+		if err := state.apply(&transactions[i], i == 0); err != nil {
+			return err
+		}
+	}
+	a.accounts = state.accounts
+	return nil
+}
+
+// Validate checks the transfer of tx is covered by the balance of its account and
+// carries its next nonce once the pending transactions are applied.
+func (a *Accounts) Validate(tx *v1alpha1.Transaction, pending []v1alpha1.Transaction) error {
+	state := a.clone()
+	for i := range pending {
+		state.apply(&pending[i], false)
+	}
+	return state.apply(tx, false)
+}
+
+// apply applies a single transaction, which may only create value when it is a coinbase.
+func (a *Accounts) apply(tx *v1alpha1.Transaction, coinbase bool) error {
+	if len(tx.Inputs) > 0 {
+		return invalid(tx, "transactions of account chains do not spend outputs")
+	}
+	if len(tx.Outputs) > 0 {
+		return a.applyCoinbase(tx, coinbase)
+	}
+	if tx.Transfer == nil {
+		// Free-form entries carry no value.
+		return nil
+	}
+
+	transfer := tx.Transfer
+	if transfer.Amount <= 0 {
+		return invalid(tx, "the amount must be positive")
+	}
+	if err := wallet.ValidateAddress(transfer.To); err != nil {
+		return invalid(tx, "transfer to %q: %v", transfer.To, err)
+	}
+	if err := wallet.VerifyTransfer(tx); err != nil {
+		return invalid(tx, "%v", err)
+	}
+	from := a.account(transfer.From)
+	if transfer.Nonce != from.Nonce {
+		return invalid(tx, "the nonce of %s is %d, not %d", transfer.From, from.Nonce, transfer.Nonce)
+	}
+	if from.Balance < transfer.Amount {
+		return invalid(tx, "%s owns %d, not enough to transfer %d", transfer.From, from.Balance, transfer.Amount)
+	}
+
+	from.Balance -= transfer.Amount
+	from.Nonce++
+	a.accounts[from.Address] = from
+	to := a.account(transfer.To)
+	balance, ok := add(to.Balance, transfer.Amount)
+	if !ok {
+		// Undo the debit, the state of a failed transaction is never kept.
+		from.Balance += transfer.Amount
+		from.Nonce--
+		a.accounts[from.Address] = from
+		return invalid(tx, "the balance of %s overflows", transfer.To)
+	}
+	to.Balance = balance
+	a.accounts[to.Address] = to
+	return nil
+}
+
+// applyCoinbase credits the outputs of the coinbase of a block.
+func (a *Accounts) applyCoinbase(tx *v1alpha1.Transaction, coinbase bool) error {
+	if !coinbase || tx.Transfer != nil {
+		return invalid(tx, "only the coinbase may create value")
+	}
+	var total int64
+	for _, output := range tx.Outputs {
+		if output.Value <= 0 {
+			return invalid(tx, "output values must be positive")
+		}
+		if err := wallet.ValidateAddress(output.Address); err != nil {
+			return invalid(tx, "output paid to %q: %v", output.Address, err)
+		}
+		var ok bool
+		if total, ok = add(total, output.Value); !ok {
+			return invalid(tx, "the value of the outputs overflows")
+		}
+	}
+	if total > a.reward {
+		return invalid(tx, "the coinbase pays %d, more than the reward of %d", total, a.reward)
+	}
+	// Check every credit before applying any, so a failed coinbase leaves no trace.
+	credited := make(map[string]v1alpha1.AccountStatus)
+	for _, output := range tx.Outputs {
+		account, ok := credited[output.Address]
+		if !ok {
+			account = a.account(output.Address)
+		}
+		if account.Balance, ok = add(account.Balance, output.Value); !ok {
+			return invalid(tx, "the balance of %s overflows", output.Address)
+		}
+		credited[output.Address] = account
+	}
+	for address, account := range credited {
+		a.accounts[address] = account
+	}
+	return nil
+}
+
+// account returns the account with the given address, accounts which never received
+// anything are empty.
+func (a *Accounts) account(address string) v1alpha1.AccountStatus {
+	if account, ok := a.accounts[address]; ok {
+		return account
+	}
+	return v1alpha1.AccountStatus{Address: address}
+}
+
+// Account returns the account with the given address.
+func (a *Accounts) Account(address string) v1alpha1.AccountStatus {
+	return a.account(address)
+}
+
+// Balance returns the balance of the account with the given address.
+func (a *Accounts) Balance(address string) int64 {
+	return a.account(address).Balance
+}
+
+// List returns every account, ordered by address.
+func (a *Accounts) List() []v1alpha1.AccountStatus {
+	accounts := make([]v1alpha1.AccountStatus, 0, len(a.accounts))
+	for _, account := range a.accounts {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Address < accounts[j].Address
+	})
+	return accounts
+}
+
+// Clone returns an independent copy of the accounts.
+func (a *Accounts) Clone() Ledger {
+	return a.clone()
+}
+
+func (a *Accounts) clone() *Accounts {
+	clone := NewAccounts(a.reward, a.rewardAddress)
+	for address, account := range a.accounts {
+		clone.accounts[address] = account
+	}
+	return clone
+}
@@ -0,0 +1,161 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ledger
+
+import (
+	"math"
+	"testing"
+
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+	"github.com/nimrodshn/kubechain/pkg/wallet"
+)
+
+// transfer returns a transfer of amount from w to to, signed by w.
+func transfer(t *testing.T, w *wallet.Wallet, to string, amount int64, nonce uint64) v1alpha1.Transaction {
+	tx := v1alpha1.Transaction{Transfer: &v1alpha1.Transfer{From: w.Address(), To: to, Amount: amount, Nonce: nonce}}
+	if err := w.Sign(&tx); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestAccountsCoinbase(t *testing.T) {
+	alice := newTestWallet(t).Address()
+	tests := []struct {
+		name    string
+		outputs []v1alpha1.TxOutput
+		valid   bool
+	}{
+		{"reward", []v1alpha1.TxOutput{{Value: 50, Address: alice}}, true},
+		{"more than the reward", []v1alpha1.TxOutput{{Value: 51, Address: alice}}, false},
+		{"zero value", []v1alpha1.TxOutput{{Value: 0, Address: alice}}, false},
+		{"negative value", []v1alpha1.TxOutput{{Value: 60, Address: alice}, {Value: -10, Address: alice}}, false},
+		{"overflow", []v1alpha1.TxOutput{{Value: math.MaxInt64, Address: alice}, {Value: math.MaxInt64, Address: alice}, {Value: 2, Address: alice}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			accounts := NewAccounts(50, "")
+			err := accounts.Apply(blockOf(coinbase(1, test.outputs...)), 1)
+			if test.valid && err != nil {
+				t.Fatalf("expected the coinbase to apply, got %v", err)
+			}
+			if !test.valid && !IsInvalidTransaction(err) {
+				t.Fatalf("expected an invalid transaction, got %v", err)
+			}
+			if !test.valid && accounts.Balance(alice) != 0 {
+				t.Fatalf("expected a rejected coinbase to pay nothing, %s owns %d", alice, accounts.Balance(alice))
+			}
+		})
+	}
+}
+
+func TestAccountsTransfer(t *testing.T) {
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	funding := coinbase(1, v1alpha1.TxOutput{Value: 50, Address: alice.Address()})
+	pay := transfer(t, alice, bob.Address(), 20, 0)
+	tampered := transfer(t, alice, bob.Address(), 20, 0)
+	tampered.Transfer.Amount = 50
+
+	tests := []struct {
+		name  string
+		block *v1alpha1.Block
+		alice int64
+		bob   int64
+		valid bool
+	}{
+		{"transfer", blockOf(coinbase(2), pay), 30, 20, true},
+		{"consecutive nonces", blockOf(coinbase(2), pay, transfer(t, alice, bob.Address(), 30, 1)), 0, 50, true},
+		{"nonce replay", blockOf(coinbase(2), pay, pay), 0, 0, false},
+		{"nonce gap", blockOf(coinbase(2), transfer(t, alice, bob.Address(), 20, 1)), 0, 0, false},
+		{"insufficient balance", blockOf(coinbase(2), transfer(t, alice, bob.Address(), 51, 0)), 0, 0, false},
+		{"zero amount", blockOf(coinbase(2), transfer(t, alice, bob.Address(), 0, 0)), 0, 0, false},
+		{"negative amount", blockOf(coinbase(2), transfer(t, alice, bob.Address(), -1, 0)), 0, 0, false},
+		{"not the owner", blockOf(coinbase(2), transfer(t, bob, alice.Address(), 1, 0)), 0, 0, false},
+		{"tampered", blockOf(coinbase(2), tampered), 0, 0, false},
+		{"inputs", blockOf(coinbase(2), v1alpha1.Transaction{Inputs: []v1alpha1.TxInput{{TxID: funding.Hash()}}}), 0, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			accounts := NewAccounts(50, "")
+			if err := accounts.Apply(blockOf(funding), 1); err != nil {
+				t.Fatal(err)
+			}
+			err := accounts.Apply(test.block, 2)
+			if !test.valid {
+				if !IsInvalidTransaction(err) {
+					t.Fatalf("expected an invalid transaction, got %v", err)
+				}
+				// Blocks are applied atomically.
+				test.alice, test.bob = 50, 0
+			} else if err != nil {
+				t.Fatalf("expected the block to apply, got %v", err)
+			}
+			if got := accounts.Balance(alice.Address()); got != test.alice {
+				t.Errorf("expected alice to own %d, got %d", test.alice, got)
+			}
+			if got := accounts.Balance(bob.Address()); got != test.bob {
+				t.Errorf("expected bob to own %d, got %d", test.bob, got)
+			}
+		})
+	}
+}
+
+func TestAccountsNonce(t *testing.T) {
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	accounts := NewAccounts(50, "")
+	if err := accounts.Apply(blockOf(coinbase(1, v1alpha1.TxOutput{Value: 50, Address: alice.Address()})), 1); err != nil {
+		t.Fatal(err)
+	}
+	pay := transfer(t, alice, bob.Address(), 10, 0)
+	if err := accounts.Apply(blockOf(coinbase(2), pay), 2); err != nil {
+		t.Fatal(err)
+	}
+	if nonce := accounts.Account(alice.Address()).Nonce; nonce != 1 {
+		t.Fatalf("expected the nonce of alice to be 1, got %d", nonce)
+	}
+	// A transfer applied in an earlier block cannot be replayed.
+	if err := accounts.Apply(blockOf(coinbase(3), pay), 3); !IsInvalidTransaction(err) {
+		t.Fatalf("expected the replayed transfer to be invalid, got %v", err)
+	}
+	// Nor can a transfer pending in the mempool.
+	next := transfer(t, alice, bob.Address(), 10, 1)
+	if err := accounts.Validate(&next, []v1alpha1.Transaction{next}); !IsInvalidTransaction(err) {
+		t.Fatalf("expected a transfer replaying a pending one to be invalid, got %v", err)
+	}
+}
+
+func TestAccountsCreditOverflow(t *testing.T) {
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	accounts := NewAccounts(math.MaxInt64, "")
+	funding := coinbase(1, v1alpha1.TxOutput{Value: math.MaxInt64, Address: bob.Address()})
+	if err := accounts.Apply(blockOf(funding), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := accounts.Apply(blockOf(coinbase(2, v1alpha1.TxOutput{Value: 1, Address: alice.Address()})), 2); err != nil {
+		t.Fatal(err)
+	}
+	err := accounts.Apply(blockOf(coinbase(3), transfer(t, alice, bob.Address(), 1, 0)), 3)
+	if !IsInvalidTransaction(err) {
+		t.Fatalf("expected a transfer overflowing the balance of bob to be invalid, got %v", err)
+	}
+	err = accounts.Apply(blockOf(coinbase(3, v1alpha1.TxOutput{Value: 1, Address: bob.Address()})), 3)
+	if !IsInvalidTransaction(err) {
+		t.Fatalf("expected a coinbase overflowing the balance of bob to be invalid, got %v", err)
+	}
+	if accounts.Balance(alice.Address()) != 1 || accounts.Balance(bob.Address()) != math.MaxInt64 {
+		t.Fatalf("expected the rejected blocks to leave the balances unchanged")
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ledger defines the state updated by the transactions of a blockchain.
+package ledger
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/golang/glog"
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+)
+
+// Ledger is the state of a blockchain at one of its blocks, e.g. the set of unspent
+// outputs. Ledgers are not safe for concurrent use, callers serialize access to them.
+type Ledger interface {
+	// Prepare drops the transactions of block which cannot be applied on top of the
+	// ledger and adds the transaction paying the reward of the block at height.
+	Prepare(block *v1alpha1.Block, height int64)
+	// Apply validates the transactions of block, at height, against the ledger and
+	// applies them. Nothing is applied when an error is returned.
+	Apply(block *v1alpha1.Block, height int64) error
+	// Validate checks that tx can be applied on top of the ledger once the pending
+	// transactions which can be applied are.
+	Validate(tx *v1alpha1.Transaction, pending []v1alpha1.Transaction) error
+	// Balance returns the value owned by address.
+	Balance(address string) int64
+	// Clone returns an independent copy of the ledger.
+	Clone() Ledger
+}
+
+// Config holds the controller-wide settings of the ledgers.
+type Config struct {
+	// RewardAddress is the address the rewards of the blocks mined by the controller
+	// are paid to. No reward is claimed when it is empty.
+	RewardAddress string
+}
+
+// New returns the empty ledger the blockchain is configured with, it is nil when
+// the blockchain has no ledger.
+func New(blockchain *v1alpha1.Blockchain, config Config) (Ledger, error) {
+	switch blockchain.Spec.Ledger {
+	case "":
+		return nil, nil
+	case v1alpha1.LedgerUTXO:
+		return NewUTXOSet(blockchain.BlockReward(), config.RewardAddress), nil
+	case v1alpha1.LedgerAccount:
+		return NewAccounts(blockchain.BlockReward(), config.RewardAddress), nil
+	default:
+		return nil, fmt.Errorf("blockchain %s/%s uses unknown ledger %q", blockchain.Namespace, blockchain.Name, blockchain.Spec.Ledger)
+	}
+}
+
+// InvalidTransactionError is returned when a transaction breaks the rules of the ledger.
+type InvalidTransactionError struct {
+	// ID is the ID of the transaction.
+	ID     []byte
+	Reason string
+}
+
+func (e *InvalidTransactionError) Error() string {
+	return fmt.Sprintf("transaction %x is invalid: %s", e.ID, e.Reason)
+}
+
+// IsInvalidTransaction reports whether err means a transaction breaks the rules of the ledger.
+func IsInvalidTransaction(err error) bool {
+	_, ok := err.(*InvalidTransactionError)
+	return ok
+}
+
+func invalid(tx *v1alpha1.Transaction, format string, args ...interface{}) error {
+	return &InvalidTransactionError{ID: tx.Hash(), Reason: fmt.Sprintf(format, args...)}
+}
+
+// add returns the sum of two values, ok is false when it overflows. Values are
+// never negative, so only overflows past math.MaxInt64 are possible.
+func add(a, b int64) (sum int64, ok bool) {
+	if b > math.MaxInt64-a {
+		return 0, false
+	}
+	return a + b, true
+}
+
+// prepare replaces the transactions of block with the coinbase paying reward to
+// rewardAddress, if any, followed by those of its transactions which apply to the
+// state apply updates.
+func prepare(block *v1alpha1.Block, height int64, rewardAddress string, reward int64, apply func(tx *v1alpha1.Transaction, coinbase bool) error) {
+	var transactions []v1alpha1.Transaction
+	if rewardAddress != "" {
+		coinbase := v1alpha1.NewCoinbase(rewardAddress, reward, height)
+		if err := apply(&coinbase, true); err != nil {
+			glog.Errorf("Not claiming the reward of block %s/%s: %v", block.Namespace, block.Name, err)
+		} else {
+			transactions = append(transactions, coinbase)
+		}
+	}
+	// Blocks carrying a single free-form Data keep it as a transaction next to the coinbase.
+	entries := block.Transactions()
+	for i := range entries {
+		tx := &entries[i]
+		if err := apply(tx, false); err != nil {
+			glog.Warningf("Dropping transaction from block %s/%s: %v", block.Namespace, block.Name, err)
+			continue
+		}
+		transactions = append(transactions, *tx)
+	}
+	block.Spec.Transactions = transactions
+}
@@ -0,0 +1,187 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ledger
+
+import (
+	"encoding/hex"
+	"sort"
+
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+	"github.com/nimrodshn/kubechain/pkg/wallet"
+)
+
+// UTXOSet is the set of the outputs of the transactions of a blockchain which were
+// not spent yet. Every transaction but the coinbase spends unspent outputs into new
+// outputs of the same total value, the coinbase, the first transaction of a block,
+// creates the reward of the block out of nothing. Outputs are paid to wallet
+// addresses and each input must be signed by the wallet owning the output it spends.
+type UTXOSet struct {
+	reward        int64
+	rewardAddress string
+	// unspent holds the unspent outputs keyed by the transaction and the index they belong to.
+	unspent map[outpoint]v1alpha1.TxOutput
+}
+
+// outpoint designates an output of a transaction.
+type outpoint struct {
+	txID string
+	vout int
+}
+
+// NewUTXOSet is a constructor for an empty set paying reward for each block,
+// to rewardAddress for the blocks prepared on top of it.
+func NewUTXOSet(reward int64, rewardAddress string) *UTXOSet {
+	return &UTXOSet{
+		reward:        reward,
+		rewardAddress: rewardAddress,
+		unspent:       make(map[outpoint]v1alpha1.TxOutput),
+	}
+}
+
+// Prepare drops the transactions of block which spend outputs that do not exist or
+// are already spent and adds the coinbase of the block in front of the others.
+func (s *UTXOSet) Prepare(block *v1alpha1.Block, height int64) {
+	prepare(block, height, s.rewardAddress, s.reward, s.clone().apply)
+}
+
+// Apply spends the outputs spent by the transactions of block and adds their outputs.
+func (s *UTXOSet) Apply(block *v1alpha1.Block, height int64) error {
+	state := s.clone()
+	transactions := block.Transactions()
+	for i := range transactions {
+		if err := state.apply(&transactions[i], i == 0); err != nil {
+			return err
+		}
+	}
+	s.unspent = state.unspent
+	return nil
+}
+
+// Validate checks the inputs of tx are unspent once the pending transactions are applied.
+func (s *UTXOSet) Validate(tx *v1alpha1.Transaction, pending []v1alpha1.Transaction) error {
+	state := s.clone()
+	for i := range pending {
+		state.apply(&pending[i], false)
+	}
+	return state.apply(tx, false)
+}
+
+// apply applies a single transaction, which may only create value when it is a coinbase.
+func (s *UTXOSet) apply(tx *v1alpha1.Transaction, coinbase bool) error {
+	if tx.Transfer != nil {
+		return invalid(tx, "transfers are only valid on account chains")
+	}
+	// Free-form entries carry no value.
+	if len(tx.Inputs) == 0 && len(tx.Outputs) == 0 {
+		return nil
+	}
+
+	var in, out int64
+	for _, output := range tx.Outputs {
+		if output.Value <= 0 {
+			return invalid(tx, "output values must be positive")
+		}
+		if err := wallet.ValidateAddress(output.Address); err != nil {
+			return invalid(tx, "output paid to %q: %v", output.Address, err)
+		}
+		var ok bool
+		if out, ok = add(out, output.Value); !ok {
+			return invalid(tx, "the value of the outputs overflows")
+		}
+	}
+
+	if len(tx.Inputs) == 0 {
+		if !coinbase {
+			return invalid(tx, "only the coinbase may create value")
+		}
+		if out > s.reward {
+			return invalid(tx, "the coinbase pays %d, more than the reward of %d", out, s.reward)
+		}
+	}
+	spent := make(map[outpoint]bool)
+	for i, input := range tx.Inputs {
+		point := outpoint{txID: hex.EncodeToString(input.TxID), vout: input.Vout}
+		output, ok := s.unspent[point]
+		if !ok || spent[point] {
+			return invalid(tx, "output %d of transaction %x does not exist or is already spent", input.Vout, input.TxID)
+		}
+		if err := wallet.Verify(tx, i, output.Address); err != nil {
+			return invalid(tx, "%v", err)
+		}
+		spent[point] = true
+		if in, ok = add(in, output.Value); !ok {
+			return invalid(tx, "the value of the inputs overflows")
+		}
+	}
+	if len(tx.Inputs) > 0 && in != out {
+		return invalid(tx, "the inputs are worth %d but the outputs %d", in, out)
+	}
+
+	id := hex.EncodeToString(tx.Hash())
+	for vout := range tx.Outputs {
+		if _, ok := s.unspent[outpoint{txID: id, vout: vout}]; ok {
+			return invalid(tx, "the transaction was already applied")
+		}
+	}
+	for point := range spent {
+		delete(s.unspent, point)
+	}
+	for vout, output := range tx.Outputs {
+		s.unspent[outpoint{txID: id, vout: vout}] = output
+	}
+	return nil
+}
+
+// Balance returns the total value of the outputs paid to address which are unspent.
+func (s *UTXOSet) Balance(address string) int64 {
+	var balance int64
+	for _, output := range s.UnspentOutputs(address) {
+		balance += output.Value
+	}
+	return balance
+}
+
+// UnspentOutputs returns the outputs paid to address which are unspent, ordered by transaction ID.
+func (s *UTXOSet) UnspentOutputs(address string) []v1alpha1.UnspentOutput {
+	var outputs []v1alpha1.UnspentOutput
+	for point, output := range s.unspent {
+		if output.Address != address {
+			continue
+		}
+		id, _ := hex.DecodeString(point.txID)
+		outputs = append(outputs, v1alpha1.UnspentOutput{TxID: id, Vout: point.vout, TxOutput: output})
+	}
+	sort.Slice(outputs, func(i, j int) bool {
+		if a, b := hex.EncodeToString(outputs[i].TxID), hex.EncodeToString(outputs[j].TxID); a != b {
+			return a < b
+		}
+		return outputs[i].Vout < outputs[j].Vout
+	})
+	return outputs
+}
+
+// Clone returns an independent copy of the set.
+func (s *UTXOSet) Clone() Ledger {
+	return s.clone()
+}
+
+func (s *UTXOSet) clone() *UTXOSet {
+	clone := NewUTXOSet(s.reward, s.rewardAddress)
+	for point, output := range s.unspent {
+		clone.unspent[point] = output
+	}
+	return clone
+}
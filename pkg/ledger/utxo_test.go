@@ -0,0 +1,152 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ledger
+
+import (
+	"math"
+	"testing"
+
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+	"github.com/nimrodshn/kubechain/pkg/wallet"
+)
+
+func newTestWallet(t *testing.T) *wallet.Wallet {
+	w, err := wallet.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func blockOf(transactions ...v1alpha1.Transaction) *v1alpha1.Block {
+	return &v1alpha1.Block{Spec: v1alpha1.BlockSpec{Transactions: transactions}}
+}
+
+func coinbase(height int64, outputs ...v1alpha1.TxOutput) v1alpha1.Transaction {
+	tx := v1alpha1.NewCoinbase("", 0, height)
+	tx.Outputs = outputs
+	return tx
+}
+
+// spend returns a transaction signed by w spending the first output of from into outputs.
+func spend(t *testing.T, w *wallet.Wallet, from v1alpha1.Transaction, outputs ...v1alpha1.TxOutput) v1alpha1.Transaction {
+	tx := v1alpha1.Transaction{
+		Inputs:  []v1alpha1.TxInput{{TxID: from.Hash(), Vout: 0}},
+		Outputs: outputs,
+	}
+	if err := w.Sign(&tx); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestUTXOCoinbase(t *testing.T) {
+	alice := newTestWallet(t).Address()
+	tests := []struct {
+		name    string
+		outputs []v1alpha1.TxOutput
+		valid   bool
+	}{
+		{"reward", []v1alpha1.TxOutput{{Value: 50, Address: alice}}, true},
+		{"split reward", []v1alpha1.TxOutput{{Value: 20, Address: alice}, {Value: 30, Address: alice}}, true},
+		{"less than the reward", []v1alpha1.TxOutput{{Value: 1, Address: alice}}, true},
+		{"more than the reward", []v1alpha1.TxOutput{{Value: 51, Address: alice}}, false},
+		{"zero value", []v1alpha1.TxOutput{{Value: 0, Address: alice}}, false},
+		{"negative value", []v1alpha1.TxOutput{{Value: 60, Address: alice}, {Value: -10, Address: alice}}, false},
+		{"overflow", []v1alpha1.TxOutput{{Value: math.MaxInt64, Address: alice}, {Value: math.MaxInt64, Address: alice}, {Value: 2, Address: alice}}, false},
+		{"invalid address", []v1alpha1.TxOutput{{Value: 50, Address: "alice"}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			set := NewUTXOSet(50, "")
+			err := set.Apply(blockOf(coinbase(1, test.outputs...)), 1)
+			if test.valid && err != nil {
+				t.Fatalf("expected the coinbase to apply, got %v", err)
+			}
+			if !test.valid && !IsInvalidTransaction(err) {
+				t.Fatalf("expected an invalid transaction, got %v", err)
+			}
+			if !test.valid && set.Balance(alice) != 0 {
+				t.Fatalf("expected a rejected coinbase to pay nothing, %s owns %d", alice, set.Balance(alice))
+			}
+		})
+	}
+}
+
+func TestUTXOSpend(t *testing.T) {
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	funding := coinbase(1, v1alpha1.TxOutput{Value: 50, Address: alice.Address()})
+	pay := spend(t, alice, funding, v1alpha1.TxOutput{Value: 50, Address: bob.Address()})
+	unsigned := pay
+	unsigned.Inputs = []v1alpha1.TxInput{{TxID: funding.Hash(), Vout: 0}}
+
+	tests := []struct {
+		name  string
+		block *v1alpha1.Block
+		valid bool
+	}{
+		{"spend", blockOf(coinbase(2), pay), true},
+		{"double spend", blockOf(coinbase(2), pay, spend(t, alice, funding, v1alpha1.TxOutput{Value: 50, Address: alice.Address()})), false},
+		{"replay", blockOf(coinbase(2), pay, pay), false},
+		{"unsigned", blockOf(coinbase(2), unsigned), false},
+		{"not the owner", blockOf(coinbase(2), spend(t, bob, funding, v1alpha1.TxOutput{Value: 50, Address: bob.Address()})), false},
+		{"unbalanced", blockOf(coinbase(2), spend(t, alice, funding, v1alpha1.TxOutput{Value: 51, Address: bob.Address()})), false},
+		{"overflow", blockOf(coinbase(2), spend(t, alice, funding,
+			v1alpha1.TxOutput{Value: math.MaxInt64, Address: bob.Address()},
+			v1alpha1.TxOutput{Value: math.MaxInt64, Address: bob.Address()},
+			v1alpha1.TxOutput{Value: 52, Address: bob.Address()})), false},
+		{"mint", blockOf(coinbase(2), coinbase(2, v1alpha1.TxOutput{Value: 1, Address: bob.Address()})), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			set := NewUTXOSet(50, "")
+			if err := set.Apply(blockOf(funding), 1); err != nil {
+				t.Fatal(err)
+			}
+			err := set.Apply(test.block, 2)
+			if test.valid && err != nil {
+				t.Fatalf("expected the block to apply, got %v", err)
+			}
+			if !test.valid {
+				if !IsInvalidTransaction(err) {
+					t.Fatalf("expected an invalid transaction, got %v", err)
+				}
+				// Blocks are applied atomically.
+				if set.Balance(alice.Address()) != 50 || set.Balance(bob.Address()) != 0 {
+					t.Fatalf("expected the rejected block to leave the balances unchanged")
+				}
+			}
+		})
+	}
+}
+
+func TestUTXOValidateAgainstPending(t *testing.T) {
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	funding := coinbase(1, v1alpha1.TxOutput{Value: 50, Address: alice.Address()})
+	set := NewUTXOSet(50, "")
+	if err := set.Apply(blockOf(funding), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	pay := spend(t, alice, funding, v1alpha1.TxOutput{Value: 50, Address: bob.Address()})
+	if err := set.Validate(&pay, nil); err != nil {
+		t.Fatalf("expected the transaction to be valid, got %v", err)
+	}
+	again := spend(t, alice, funding, v1alpha1.TxOutput{Value: 50, Address: alice.Address()})
+	if err := set.Validate(&again, []v1alpha1.Transaction{pay}); !IsInvalidTransaction(err) {
+		t.Fatalf("expected a transaction spending a pending input to be invalid, got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replication
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/raft"
+	"github.com/nimrodshn/kubechain/pkg/chain"
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+)
+
+const (
+	// commandAppend appends a single sealed block to the tip of a blockchain.
+	commandAppend = "Append"
+	// commandConnect connects a single sealed block anywhere in the block tree of a blockchain.
+	commandConnect = "Connect"
+	// commandReset replaces the blocks of a blockchain, e.g. when it is recovered.
+	commandReset = "Reset"
+)
+
+// command is an entry of the raft log. It carries the blockchain it applies to, so
+// replicas which do not track the blockchain yet do not have to look it up and every
+// replica verifies the blocks of the command under the same spec.
+type command struct {
+	Type       string               `json:"type"`
+	Chain      string               `json:"chain"`
+	Blockchain *v1alpha1.Blockchain `json:"blockchain"`
+	Blocks     []*v1alpha1.Block    `json:"blocks"`
+}
+
+// result is the outcome of applying a command, returned to the replica which proposed it.
+type result struct {
+	// height is the height of an appended block.
+	height int64
+	// reorg is the change of the main chain caused by a connected block.
+	reorg *v1alpha1.Reorg
+	// rejected are the blocks a reset could not connect.
+	rejected []*v1alpha1.Block
+	err      error
+}
+
+// fsm applies the raft log to the chain manager of the replica. Every replica
+// applies the same commands in the same order, so they all hold the same chains.
+// Applying a command only depends on the command and on the chains, it does no I/O.
+type fsm struct {
+	chains *chain.Manager
+	// track makes sure blockchain is managed by chains with the spec it carries,
+	// without doing any I/O.
+	track func(blockchain *v1alpha1.Blockchain) error
+}
+
+// Apply applies a committed command.
+func (f *fsm) Apply(log *raft.Log) interface{} {
+	var cmd command
+	if err := json.Unmarshal(log.Data, &cmd); err != nil {
+		return result{err: fmt.Errorf("failed to decode raft log entry %d: %v", log.Index, err)}
+	}
+	if cmd.Blockchain == nil {
+		return result{err: fmt.Errorf("raft log entry %d carries no blockchain", log.Index)}
+	}
+	if err := f.track(cmd.Blockchain); err != nil {
+		glog.Errorf("Failed to apply raft log entry %d to blockchain %s: %v", log.Index, cmd.Chain, err)
+		return result{err: err}
+	}
+
+	switch cmd.Type {
+	case commandAppend:
+		if len(cmd.Blocks) != 1 {
+			return result{err: fmt.Errorf("raft log entry %d appends %d blocks", log.Index, len(cmd.Blocks))}
+		}
+		height, err := f.chains.Append(cmd.Chain, cmd.Blocks[0])
+		return result{height: height, err: err}
+	case commandConnect:
+		if len(cmd.Blocks) != 1 {
+			return result{err: fmt.Errorf("raft log entry %d connects %d blocks", log.Index, len(cmd.Blocks))}
+		}
+		reorg, err := f.chains.Connect(cmd.Chain, cmd.Blocks[0])
+		return result{reorg: reorg, err: err}
+	case commandReset:
+		rejected, err := f.chains.Reset(cmd.Chain, cmd.Blocks)
+		return result{rejected: rejected, err: err}
+	default:
+		return result{err: fmt.Errorf("unknown command %q in raft log entry %d", cmd.Type, log.Index)}
+	}
+}
+
+// Snapshot captures every blockchain with its block tree.
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	snapshot := &snapshot{chains: make(map[string]snapshotChain)}
+	for _, key := range f.chains.Keys() {
+		blockchain, err := f.chains.Blockchain(key)
+		if err != nil {
+			return nil, err
+		}
+		blocks, err := f.chains.AllBlocks(key)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.chains[key] = snapshotChain{Blockchain: blockchain, Blocks: blocks}
+	}
+	return snapshot, nil
+}
+
+// Restore replaces the block trees of every blockchain with the ones of a snapshot.
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	chains := make(map[string]snapshotChain)
+	if err := json.NewDecoder(rc).Decode(&chains); err != nil {
+		return fmt.Errorf("failed to decode raft snapshot: %v", err)
+	}
+	// Blockchains missing from the snapshot had no blocks when it was taken.
+	for _, key := range f.chains.Keys() {
+		if _, ok := chains[key]; !ok {
+			if _, err := f.chains.Reset(key, nil); err != nil {
+				return err
+			}
+		}
+	}
+	for key, chain := range chains {
+		if chain.Blockchain == nil {
+			return fmt.Errorf("blockchain %s of the raft snapshot carries no spec", key)
+		}
+		if err := f.track(chain.Blockchain); err != nil {
+			return err
+		}
+		if _, err := f.chains.Reset(key, chain.Blocks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshot is a point in time copy of the blockchains.
+type snapshot struct {
+	chains map[string]snapshotChain
+}
+
+// snapshotChain is a blockchain along with its block tree.
+type snapshotChain struct {
+	Blockchain *v1alpha1.Blockchain `json:"blockchain"`
+	Blocks     []*v1alpha1.Block    `json:"blocks"`
+}
+
+// Persist writes the snapshot to sink.
+func (s *snapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s.chains); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+// Release is a no-op, the snapshot holds copies of the blocks.
+func (s *snapshot) Release() {}
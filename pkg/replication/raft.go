@@ -0,0 +1,420 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package replication replicates the blockchains across controller replicas with raft,
+// so the replicas agree on the order of the blocks and all hold the same chains.
+package replication
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+	"github.com/nimrodshn/kubechain/pkg/chain"
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+)
+
+const (
+	// applyTimeout bounds how long proposing a command to the raft log may take.
+	applyTimeout = 10 * time.Second
+	// barrierBackoff and maxBarrierBackoff bound how long a new leader waits before
+	// trying again to apply the raft log.
+	barrierBackoff    = time.Second
+	maxBarrierBackoff = 30 * time.Second
+	// discoveryInterval is how often the peer Service is resolved.
+	discoveryInterval = 5 * time.Second
+	// transportTimeout bounds the raft network operations.
+	transportTimeout = 10 * time.Second
+	// maxPool is the number of connections kept open to every peer.
+	maxPool = 3
+	// retainSnapshots is the number of snapshots kept in the data directory.
+	retainSnapshots = 2
+)
+
+// Config configures a raft replica.
+type Config struct {
+	// ID identifies the replica in the cluster, its advertised address is used when empty.
+	ID string
+	// BindAddress is the host:port the raft transport listens on.
+	BindAddress string
+	// AdvertiseAddress is the host:port the other replicas reach this one at,
+	// BindAddress is used when empty.
+	AdvertiseAddress string
+	// DataDir holds the raft log and the snapshots.
+	DataDir string
+	// Peers is a static list of the replicas of the cluster, as id=host:port or host:port.
+	Peers []string
+	// PeerService is the DNS name of a headless Service of a StatefulSet, whose raft
+	// port is named raft. Its SRV records resolve to the stable host names of the
+	// replicas, which must advertise them and are identified by them.
+	PeerService string
+	// BootstrapExpect is the number of replicas PeerService must resolve to before
+	// the cluster is bootstrapped by the first BootstrapExpect of them.
+	BootstrapExpect int
+}
+
+// Raft orders the appends to the blockchains through a raft log. Only the leader
+// proposes blocks, every replica applies the committed blocks to its chain manager.
+type Raft struct {
+	config   Config
+	chains   *chain.Manager
+	raft     *raft.Raft
+	store    *raftboltdb.BoltStore
+	leaderCh chan bool
+	stopCh   chan struct{}
+	// blockchains returns the blockchain the commands proposed for a key carry.
+	blockchains func(key string) (*v1alpha1.Blockchain, error)
+}
+
+// New is a constructor for a raft replica, it does not join the cluster until it is started.
+func New(config Config) *Raft {
+	if config.AdvertiseAddress == "" {
+		config.AdvertiseAddress = config.BindAddress
+	}
+	if config.ID == "" {
+		config.ID = config.AdvertiseAddress
+	}
+	return &Raft{
+		config:   config,
+		leaderCh: make(chan bool),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start joins the raft cluster, bootstrapping it on first start, and applies the
+// committed blocks to chains. track must make sure a blockchain is managed by chains
+// with the spec it carries without doing any I/O, it is called while applying the raft
+// log. blockchains returns the blockchain with a given key, whose spec is carried by
+// the commands proposed for it.
+func (r *Raft) Start(chains *chain.Manager, track func(blockchain *v1alpha1.Blockchain) error,
+	blockchains func(key string) (*v1alpha1.Blockchain, error)) error {
+	r.chains = chains
+	r.blockchains = blockchains
+	if err := os.MkdirAll(r.config.DataDir, 0700); err != nil {
+		return err
+	}
+	advertise, err := net.ResolveTCPAddr("tcp", r.config.AdvertiseAddress)
+	if err != nil {
+		return fmt.Errorf("invalid raft advertise address %s: %v", r.config.AdvertiseAddress, err)
+	}
+	transport, err := raft.NewTCPTransport(r.config.BindAddress, advertise, maxPool, transportTimeout, os.Stderr)
+	if err != nil {
+		return err
+	}
+	// The bolt store holds both the raft log and the raft metadata (term, vote).
+	r.store, err = raftboltdb.NewBoltStore(filepath.Join(r.config.DataDir, "raft.db"))
+	if err != nil {
+		return err
+	}
+	snapshots, err := raft.NewFileSnapshotStore(r.config.DataDir, retainSnapshots, os.Stderr)
+	if err != nil {
+		return err
+	}
+	existing, err := raft.HasExistingState(r.store, r.store, snapshots)
+	if err != nil {
+		return err
+	}
+
+	config := raft.DefaultConfig()
+	config.LocalID = raft.ServerID(r.config.ID)
+	notifyCh := make(chan bool, 1)
+	config.NotifyCh = notifyCh
+
+	r.raft, err = raft.NewRaft(config, &fsm{chains: chains, track: track}, r.store, r.store, snapshots, transport)
+	if err != nil {
+		return err
+	}
+	go r.watchLeadership(notifyCh)
+
+	// Only replicas without any raft state which are part of the initial cluster
+	// bootstrap it, the others are added by the leader once they are discovered.
+	if !existing {
+		servers, err := r.bootstrapServers()
+		if err != nil {
+			return err
+		}
+		if hasServer(servers, config.LocalID) {
+			glog.Infof("Bootstrapping raft cluster with %d replicas", len(servers))
+			err = r.raft.BootstrapCluster(raft.Configuration{Servers: servers}).Error()
+			if err != nil && err != raft.ErrCantBootstrap {
+				return err
+			}
+		} else {
+			glog.Infof("Replica %s is not part of the initial raft cluster, waiting to be added by the leader", r.config.ID)
+		}
+	}
+	if r.config.PeerService != "" {
+		go r.reconcilePeers()
+	}
+	return nil
+}
+
+// Append proposes a sealed block to the blockchain with the given key and returns
+// its height once the block is committed and applied. It fails when the replica is
+// not the leader.
+func (r *Raft) Append(key string, block *v1alpha1.Block) (int64, error) {
+	res, err := r.apply(command{Type: commandAppend, Chain: key, Blocks: []*v1alpha1.Block{block}})
+	return res.height, err
+}
+
+// Connect proposes to connect a sealed block anywhere in the block tree of the
+// blockchain with the given key and returns the change of its main chain once the
+// block is committed and applied, see chain.Manager.Connect.
+func (r *Raft) Connect(key string, block *v1alpha1.Block) (*v1alpha1.Reorg, error) {
+	res, err := r.apply(command{Type: commandConnect, Chain: key, Blocks: []*v1alpha1.Block{block}})
+	return res.reorg, err
+}
+
+// Reset proposes to replace the block tree of the blockchain with the given key and
+// returns the blocks which could not be connected once the command is applied.
+func (r *Raft) Reset(key string, blocks []*v1alpha1.Block) ([]*v1alpha1.Block, error) {
+	res, err := r.apply(command{Type: commandReset, Chain: key, Blocks: blocks})
+	return res.rejected, err
+}
+
+// apply proposes cmd along with its blockchain, so applying the command never has to
+// look it up and every replica verifies its blocks under the same spec.
+func (r *Raft) apply(cmd command) (result, error) {
+	blockchain, err := r.blockchains(cmd.Chain)
+	if err != nil {
+		return result{}, err
+	}
+	cmd.Blockchain = blockchain
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return result{}, err
+	}
+	future := r.raft.Apply(data, applyTimeout)
+	if err := future.Error(); err != nil {
+		return result{}, err
+	}
+	res := future.Response().(result)
+	return res, res.err
+}
+
+// IsLeader reports whether the replica is the leader of the cluster.
+func (r *Raft) IsLeader() bool {
+	return r.raft.State() == raft.Leader
+}
+
+// LeaderChanges delivers true when the replica becomes the leader, once every block
+// committed by the previous leaders is applied, and false when it stops being the leader.
+func (r *Raft) LeaderChanges() <-chan bool {
+	return r.leaderCh
+}
+
+// Shutdown leaves the raft cluster.
+func (r *Raft) Shutdown() error {
+	close(r.stopCh)
+	if r.raft == nil {
+		return nil
+	}
+	if err := r.raft.Shutdown().Error(); err != nil {
+		return err
+	}
+	return r.store.Close()
+}
+
+func (r *Raft) watchLeadership(notifyCh <-chan bool) {
+	for {
+		select {
+		case leader := <-notifyCh:
+			if leader {
+				glog.Infof("Replica %s is now the raft leader", r.config.ID)
+				// Leadership lost in the meantime is notified next.
+				if !r.awaitApplied() {
+					continue
+				}
+			} else {
+				glog.Infof("Replica %s is no longer the raft leader", r.config.ID)
+			}
+			select {
+			case r.leaderCh <- leader:
+			case <-r.stopCh:
+				return
+			}
+		case <-r.stopCh:
+			return
+		}
+	}
+}
+
+// awaitApplied waits until every entry of the raft log is applied, which is only known
+// once an entry of the current term is, retrying with backoff. It returns false when
+// the replica stops being the leader or shuts down first.
+func (r *Raft) awaitApplied() bool {
+	backoff := barrierBackoff
+	for {
+		err := r.raft.Barrier(applyTimeout).Error()
+		if err == nil {
+			return true
+		}
+		if r.raft.State() != raft.Leader {
+			glog.Infof("Replica %s lost the raft leadership before applying the raft log: %v", r.config.ID, err)
+			return false
+		}
+		glog.Errorf("Failed to apply the raft log after becoming the leader, retrying in %v: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-r.stopCh:
+			return false
+		}
+		if backoff *= 2; backoff > maxBarrierBackoff {
+			backoff = maxBarrierBackoff
+		}
+	}
+}
+
+// bootstrapServers returns the replicas the cluster is bootstrapped with. Every replica
+// bootstraps with the same servers, either the static peers or, with a peer Service,
+// the first BootstrapExpect replicas it resolves to ordered by host name, i.e. the
+// first pods of the StatefulSet.
+func (r *Raft) bootstrapServers() ([]raft.Server, error) {
+	self := raft.Server{ID: raft.ServerID(r.config.ID), Address: raft.ServerAddress(r.config.AdvertiseAddress)}
+
+	if r.config.PeerService == "" {
+		servers := []raft.Server{self}
+		for _, peer := range r.config.Peers {
+			server, err := parsePeer(peer)
+			if err != nil {
+				return nil, err
+			}
+			if server.ID != self.ID {
+				servers = append(servers, server)
+			}
+		}
+		return servers, nil
+	}
+
+	for {
+		addresses, err := r.resolvePeers()
+		if err != nil {
+			glog.Warningf("Failed to resolve raft peers from %s: %v", r.config.PeerService, err)
+		} else if len(addresses) >= r.config.BootstrapExpect {
+			var servers []raft.Server
+			for _, address := range addresses[:r.config.BootstrapExpect] {
+				servers = append(servers, raft.Server{ID: raft.ServerID(address), Address: raft.ServerAddress(address)})
+			}
+			return servers, nil
+		} else {
+			glog.Infof("Waiting for %d raft peers, %s resolves to %d", r.config.BootstrapExpect, r.config.PeerService, len(addresses))
+		}
+
+		select {
+		case <-time.After(discoveryInterval):
+		case <-r.stopCh:
+			return nil, fmt.Errorf("stopped while discovering raft peers")
+		}
+	}
+}
+
+// reconcilePeers keeps the raft configuration in sync with the replicas the peer
+// Service resolves to, so replacement pods join the cluster and lost ones leave it.
+// Only the leader changes the configuration.
+func (r *Raft) reconcilePeers() {
+	ticker := time.NewTicker(discoveryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.stopCh:
+			return
+		}
+		if !r.IsLeader() {
+			continue
+		}
+
+		addresses, err := r.resolvePeers()
+		if err != nil || len(addresses) == 0 {
+			continue
+		}
+		wanted := make(map[raft.ServerID]bool)
+		for _, address := range addresses {
+			wanted[raft.ServerID(address)] = true
+		}
+
+		future := r.raft.GetConfiguration()
+		if err := future.Error(); err != nil {
+			glog.Errorf("Failed to get the raft configuration: %v", err)
+			continue
+		}
+		current := make(map[raft.ServerID]bool)
+		for _, server := range future.Configuration().Servers {
+			current[server.ID] = true
+			if !wanted[server.ID] && server.ID != raft.ServerID(r.config.ID) {
+				glog.Infof("Removing raft peer %s", server.ID)
+				if err := r.raft.RemoveServer(server.ID, 0, 0).Error(); err != nil {
+					glog.Errorf("Failed to remove raft peer %s: %v", server.ID, err)
+				}
+			}
+		}
+		for _, address := range addresses {
+			if current[raft.ServerID(address)] {
+				continue
+			}
+			glog.Infof("Adding raft peer %s", address)
+			if err := r.raft.AddVoter(raft.ServerID(address), raft.ServerAddress(address), 0, 0).Error(); err != nil {
+				glog.Errorf("Failed to add raft peer %s: %v", address, err)
+			}
+		}
+	}
+}
+
+// resolvePeers returns the sorted addresses of the replicas behind the peer Service,
+// from its SRV records, which hold the stable host names of the StatefulSet pods.
+func (r *Raft) resolvePeers() ([]string, error) {
+	_, records, err := net.LookupSRV("raft", "tcp", r.config.PeerService)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]string, 0, len(records))
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		addresses = append(addresses, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+	}
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+// hasServer reports whether servers holds the server with the given id.
+func hasServer(servers []raft.Server, id raft.ServerID) bool {
+	for _, server := range servers {
+		if server.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePeer parses a static peer given as id=host:port or host:port.
+func parsePeer(peer string) (raft.Server, error) {
+	id, address := peer, peer
+	if i := strings.Index(peer, "="); i >= 0 {
+		id, address = peer[:i], peer[i+1:]
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return raft.Server{}, fmt.Errorf("invalid raft peer %q: %v", peer, err)
+	}
+	return raft.Server{ID: raft.ServerID(id), Address: raft.ServerAddress(address)}, nil
+}
@@ -16,8 +16,12 @@
 package v1alpha1
 
 import (
-	"time"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
 
+	"github.com/golang/glog"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -25,16 +29,149 @@ import (
 type Block struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,inline"`
-	Spec              BlockSpec `json:"spec"`
+	Spec              BlockSpec   `json:"spec"`
+	Status            BlockStatus `json:"status,omitempty"`
 }
 
 // BlockSpec provides specifications for the block.
 type BlockSpec struct {
-	Data          string `json:"data"`
+	// Chain is the name of the blockchain, in the same namespace, the block belongs to.
+	Chain string `json:"chain,omitempty"`
+	// Data is a free-form entry, blocks carrying a single entry may set it
+	// instead of Transactions.
+	Data string `json:"data,omitempty"`
+	// Transactions are the entries carried by the block.
+	Transactions []Transaction `json:"transactions,omitempty"`
+	// MerkleRoot is the root of the merkle tree over the transactions, it is part of
+	// the hashed header so each transaction can be proven to be part of the block.
+	MerkleRoot    []byte `json:"merkleRoot,omitempty"`
 	Timestamp     int64  `json:"timestamp,omitempty"`
 	PrevBlockHash []byte `json:"prev_block_hash,omitempty"`
 	Hash          []byte `json:"hash,omitempty"`
 	Nonce         int    `json:"nonce,omitempty"`
+	// Difficulty is the number of leading zero bits the block was mined with,
+	// it is set from the blockchain before mining and is part of the hashed header.
+	Difficulty int64 `json:"difficulty,omitempty"`
+	// Signer is the name of the authority which signed the block on proof of authority chains.
+	Signer string `json:"signer,omitempty"`
+	// Signature is the signature of the block hash by Signer.
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// BlockPhase is a label for where a block is in its lifecycle.
+type BlockPhase string
+
+const (
+	// BlockPending means the block was accepted but the controller has not started mining it yet.
+	// A block with an empty phase is considered pending.
+	BlockPending BlockPhase = "Pending"
+	// BlockMining means the controller is currently computing the PoW for the block.
+	BlockMining BlockPhase = "Mining"
+	// BlockMined means the block was mined and appended to the blockchain.
+	BlockMined BlockPhase = "Mined"
+	// BlockFailed means the block could not be mined (e.g. PoW exceeded the timeout) and is purged,
+	// or that it was submitted sealed but is invalid.
+	BlockFailed BlockPhase = "Failed"
+	// BlockStale means the block is sealed but is on a branch of the blockchain which lost the
+	// fork choice, it becomes mined again if its branch ends up with the most work.
+	BlockStale BlockPhase = "Stale"
+	// BlockOrphan means the block is sealed but its parent is not known yet, it is held
+	// until the parent is connected or it expires.
+	BlockOrphan BlockPhase = "Orphan"
+	// BlockPruned means the block was deleted from a blockchain allowing pruning, only
+	// its header is left in a tombstone. It is never the phase of a Block resource.
+	BlockPruned BlockPhase = "Pruned"
+)
+
+// BlockFinalizer is the finalizer the controller puts on sealed blocks, so they are
+// only deleted once the controller allowed it and recorded their tombstone.
+const BlockFinalizer = "kubechain.com/sealed-block"
+
+// DeletedByAnnotation is the annotation the admission webhook records the user
+// deleting a sealed block in, it is copied into the tombstone of the block.
+const DeletedByAnnotation = "kubechain.com/deleted-by"
+
+// BlockConditionType is the type of a block condition.
+type BlockConditionType string
+
+const (
+	// BlockConditionMined indicates whether a valid PoW was found for the block.
+	BlockConditionMined BlockConditionType = "Mined"
+	// BlockConditionAppended indicates whether the block was appended to the blockchain.
+	BlockConditionAppended BlockConditionType = "Appended"
+)
+
+// ConditionStatus is the status of a condition, one of True, False or Unknown.
+type ConditionStatus string
+
+const (
+	// ConditionTrue means the condition holds.
+	ConditionTrue ConditionStatus = "True"
+	// ConditionFalse means the condition does not hold.
+	ConditionFalse ConditionStatus = "False"
+	// ConditionUnknown means the controller cannot tell whether the condition holds.
+	ConditionUnknown ConditionStatus = "Unknown"
+)
+
+// Reasons used by the controller for block conditions.
+const (
+	ReasonMiningStarted    = "MiningStarted"
+	ReasonProofOfWorkFound = "ProofOfWorkFound"
+	ReasonMiningTimeout    = "MiningTimeout"
+	ReasonMiningFailed     = "MiningFailed"
+	ReasonAppendedToChain  = "AppendedToChain"
+	ReasonReorganized      = "Reorganized"
+	ReasonForkChoiceLost   = "ForkChoiceLost"
+	ReasonInvalidBlock     = "InvalidBlock"
+	ReasonParentUnknown    = "ParentUnknown"
+	ReasonOrphanExpired    = "OrphanExpired"
+	ReasonOrphanEvicted    = "OrphanEvicted"
+)
+
+// BlockCondition describes the state of a block at a certain point.
+type BlockCondition struct {
+	Type               BlockConditionType `json:"type"`
+	Status             ConditionStatus    `json:"status"`
+	LastTransitionTime metav1.Time        `json:"lastTransitionTime,omitempty"`
+	Reason             string             `json:"reason,omitempty"`
+	Message            string             `json:"message,omitempty"`
+}
+
+// BlockStatus is the observed state of the block, as set by the controller.
+type BlockStatus struct {
+	Phase              BlockPhase       `json:"phase,omitempty"`
+	Height             int64            `json:"height,omitempty"`
+	ObservedGeneration int64            `json:"observedGeneration,omitempty"`
+	Conditions         []BlockCondition `json:"conditions,omitempty"`
+}
+
+// SetCondition adds or replaces the condition of the same type in the status.
+// The transition time is only moved forward when the condition status changes.
+func (s *BlockStatus) SetCondition(condition BlockCondition) {
+	if condition.LastTransitionTime.IsZero() {
+		condition.LastTransitionTime = metav1.Now()
+	}
+	for i := range s.Conditions {
+		if s.Conditions[i].Type != condition.Type {
+			continue
+		}
+		if s.Conditions[i].Status == condition.Status {
+			condition.LastTransitionTime = s.Conditions[i].LastTransitionTime
+		}
+		s.Conditions[i] = condition
+		return
+	}
+	s.Conditions = append(s.Conditions, condition)
+}
+
+// GetCondition returns the condition of the given type, or nil if it is not set.
+func (s *BlockStatus) GetCondition(conditionType BlockConditionType) *BlockCondition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == conditionType {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
 }
 
 // BlockList is a list of blocks.
@@ -45,15 +182,76 @@ type BlockList struct {
 	Items []Block `json:"items"`
 }
 
-// Process files in all the fields for our Block type.
-func (b *Block) Process(successChan chan<- bool) {
+// ChainName returns the name of the blockchain the block belongs to.
+func (b *Block) ChainName() string {
+	if b.Spec.Chain == "" {
+		return DefaultChainName
+	}
+	return b.Spec.Chain
+}
+
+// ExternallySealed reports whether the block was submitted already sealed, e.g.
+// imported from another cluster, rather than sealed by the controller.
+func (b *Block) ExternallySealed() bool {
+	if len(b.Spec.Hash) == 0 {
+		return false
+	}
+	return b.Status.Phase == "" || b.Status.Phase == BlockPending || b.Status.Phase == BlockOrphan
+}
+
+// Difficulty returns the difficulty recorded in the block header. Blocks mined
+// before difficulties were recorded were mined with DefaultDifficulty.
+func (b *Block) Difficulty() int64 {
+	if b.Spec.Difficulty == 0 {
+		return DefaultDifficulty
+	}
+	return b.Spec.Difficulty
+}
+
+// AuthorityHash returns the hash of the header of the block signed by the
+// signer on proof of authority chains.
+func (b *Block) AuthorityHash() []byte {
+	hash := sha256.Sum256(bytes.Join(
+		[][]byte{
+			b.Spec.PrevBlockHash,
+			b.HeaderData(),
+			IntToByteArray(b.Spec.Timestamp),
+			[]byte(b.Spec.Signer),
+		},
+		[]byte{},
+	))
+	return hash[:]
+}
+
+// SealHash returns the hash of the header of the block under the given
+// consensus, which is the hash of the block once it is sealed.
+func (b *Block) SealHash(consensus string) ([]byte, error) {
+	switch consensus {
+	case "", ConsensusProofOfWork:
+		return NewProofOfWork(b).Hash(), nil
+	case ConsensusProofOfAuthority:
+		return b.AuthorityHash(), nil
+	default:
+		return nil, fmt.Errorf("unknown consensus %q", consensus)
+	}
+}
+
+// Process runs the PoW for the block and fills in its hash and nonce, using
+// the given number of workers to search the nonce space in parallel.
+// The header, including the timestamp and the hash of the previous block,
+// must be set beforehand since the PoW commits to it. Process stops and
+// returns the context error as soon as ctx is done.
+func (b *Block) Process(ctx context.Context, workers int) error {
 
 	pow := NewProofOfWork(b)
-	nonce, hash := pow.Run()
+	nonce, hash, err := pow.RunParallel(ctx, workers)
+	if err != nil {
+		glog.Infof("Stopped mining block %s/%s after trying %d nonces", b.Namespace, b.Name, nonce)
+		return err
+	}
 
-	b.Spec.Timestamp = time.Now().Unix()
 	b.Spec.Hash = hash
 	b.Spec.Nonce = nonce
 
-	successChan <- true
+	return nil
 }
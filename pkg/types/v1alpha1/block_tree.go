@@ -0,0 +1,158 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"sort"
+)
+
+// ErrUnknownParent is returned when a block links to a block which is not part of the block tree.
+var ErrUnknownParent = errors.New("the parent of the block is not part of the blockchain")
+
+// BlockTree holds every known block of a blockchain keyed by hash, including the
+// blocks of the branches which lost the fork choice. The main chain ends at the
+// block with the most cumulative work, the block seen first wins ties.
+type BlockTree struct {
+	nodes  map[string]*treeNode
+	best   *treeNode
+	weight func(*Block) *big.Int
+}
+
+// treeNode is a block of the tree along with its position.
+type treeNode struct {
+	block  *Block
+	parent *treeNode
+	height int64
+	// work is the cumulative work of the branch ending at the block.
+	work *big.Int
+}
+
+// NewBlockTree is a constructor for an empty block tree, weight returns the
+// work each block adds to its branch.
+func NewBlockTree(weight func(*Block) *big.Int) *BlockTree {
+	return &BlockTree{
+		nodes:  make(map[string]*treeNode),
+		weight: weight,
+	}
+}
+
+// Has reports whether the block with the given hash is part of the tree.
+func (t *BlockTree) Has(hash []byte) bool {
+	_, ok := t.nodes[string(hash)]
+	return ok
+}
+
+// Len returns the number of blocks in the tree.
+func (t *BlockTree) Len() int {
+	return len(t.nodes)
+}
+
+// Add adds a sealed block whose parent is part of the tree, or a genesis block,
+// and reports whether it became the tip of the main chain. Adding a known block
+// is a no-op.
+func (t *BlockTree) Add(block *Block) (bool, error) {
+	if t.Has(block.Spec.Hash) {
+		return false, nil
+	}
+
+	node := &treeNode{block: block, work: new(big.Int).Set(t.weight(block))}
+	if len(block.Spec.PrevBlockHash) > 0 {
+		parent, ok := t.nodes[string(block.Spec.PrevBlockHash)]
+		if !ok {
+			return false, ErrUnknownParent
+		}
+		node.parent = parent
+		node.height = parent.height + 1
+		node.work.Add(node.work, parent.work)
+	}
+	t.nodes[string(block.Spec.Hash)] = node
+
+	if t.best == nil || node.work.Cmp(t.best.work) > 0 {
+		t.best = node
+		return true, nil
+	}
+	return false, nil
+}
+
+// Best returns the hash of the tip of the main chain, empty for an empty tree.
+func (t *BlockTree) Best() []byte {
+	if t.best == nil {
+		return nil
+	}
+	return t.best.block.Spec.Hash
+}
+
+// Branch returns the blocks from the genesis block up to the block with the
+// given hash, it is empty for an empty or unknown hash.
+func (t *BlockTree) Branch(hash []byte) []*Block {
+	node, ok := t.nodes[string(hash)]
+	if !ok {
+		return nil
+	}
+	branch := make([]*Block, node.height+1)
+	for ; node != nil; node = node.parent {
+		branch[node.height] = node.block
+	}
+	return branch
+}
+
+// Blocks returns every block of the tree, parents before their children.
+func (t *BlockTree) Blocks() []*Block {
+	nodes := make([]*treeNode, 0, len(t.nodes))
+	for _, node := range t.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].height < nodes[j].height
+	})
+	blocks := make([]*Block, len(nodes))
+	for i, node := range nodes {
+		blocks[i] = node.block
+	}
+	return blocks
+}
+
+// Reorg describes how the main chain of a blockchain changed when a block was connected.
+type Reorg struct {
+	// ForkHeight is the height of the first block which joined the main chain.
+	ForkHeight int64
+	// Disconnected are the blocks which left the main chain.
+	Disconnected []*Block
+	// Connected are the blocks which joined the main chain, ending at the new tip.
+	Connected []*Block
+}
+
+// NewReorg compares the main chain before and after it changed.
+func NewReorg(before, after []*Block) *Reorg {
+	fork := 0
+	for fork < len(before) && fork < len(after) && bytes.Equal(before[fork].Spec.Hash, after[fork].Spec.Hash) {
+		fork++
+	}
+	return &Reorg{
+		ForkHeight:   int64(fork),
+		Disconnected: before[fork:],
+		Connected:    after[fork:],
+	}
+}
+
+// Depth returns the number of blocks which left the main chain, it is zero when
+// the main chain was merely extended.
+func (r *Reorg) Depth() int {
+	return len(r.Disconnected)
+}
@@ -0,0 +1,139 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// newTestTree returns an empty block tree weighing every block by its difficulty.
+func newTestTree() *BlockTree {
+	return NewBlockTree(func(block *Block) *big.Int {
+		return big.NewInt(block.Spec.Difficulty)
+	})
+}
+
+// addBlock adds a block with the given hash, parent and difficulty to the tree and
+// returns whether it became the tip.
+func addBlock(t *testing.T, tree *BlockTree, hash, parent string, difficulty int64) bool {
+	block := &Block{Spec: BlockSpec{Hash: []byte(hash), Difficulty: difficulty}}
+	if parent != "" {
+		block.Spec.PrevBlockHash = []byte(parent)
+	}
+	tip, err := tree.Add(block)
+	if err != nil {
+		t.Fatalf("failed to add block %s: %v", hash, err)
+	}
+	return tip
+}
+
+// hashes joins the hashes of the given blocks, e.g. "g,a,b".
+func hashes(blocks []*Block) string {
+	var names []string
+	for _, block := range blocks {
+		names = append(names, string(block.Spec.Hash))
+	}
+	return strings.Join(names, ",")
+}
+
+func TestBlockTreeForkChoice(t *testing.T) {
+	tree := newTestTree()
+	if !addBlock(t, tree, "g", "", 1) {
+		t.Fatal("expected the genesis block to become the tip")
+	}
+	if !addBlock(t, tree, "a", "g", 2) {
+		t.Fatal("expected a block extending the tip to become the tip")
+	}
+
+	// The branch g-b-c is longer than g-a but carries the same work, the tip seen first wins.
+	if addBlock(t, tree, "b", "g", 1) || addBlock(t, tree, "c", "b", 1) {
+		t.Fatal("expected a branch without more work not to become the tip")
+	}
+	if best := string(tree.Best()); best != "a" {
+		t.Fatalf("expected a to remain the tip, got %s", best)
+	}
+
+	if !addBlock(t, tree, "d", "c", 1) {
+		t.Fatal("expected the heavier branch to become the tip")
+	}
+	if branch := hashes(tree.Branch(tree.Best())); branch != "g,b,c,d" {
+		t.Fatalf("expected the main chain g,b,c,d, got %s", branch)
+	}
+	// The losing branch is kept.
+	if branch := hashes(tree.Branch([]byte("a"))); branch != "g,a" {
+		t.Fatalf("expected the side branch g,a, got %s", branch)
+	}
+	if tree.Len() != 5 {
+		t.Fatalf("expected 5 blocks, got %d", tree.Len())
+	}
+}
+
+func TestBlockTreeAddKnownOrUnlinked(t *testing.T) {
+	tree := newTestTree()
+	addBlock(t, tree, "g", "", 1)
+	addBlock(t, tree, "a", "g", 1)
+
+	if tip, err := tree.Add(&Block{Spec: BlockSpec{Hash: []byte("a"), PrevBlockHash: []byte("g"), Difficulty: 5}}); tip || err != nil {
+		t.Fatalf("expected adding a known block to be a no-op, got %t, %v", tip, err)
+	}
+	if _, err := tree.Add(&Block{Spec: BlockSpec{Hash: []byte("x"), PrevBlockHash: []byte("y"), Difficulty: 5}}); err != ErrUnknownParent {
+		t.Fatalf("expected error %v, got %v", ErrUnknownParent, err)
+	}
+	if tree.Has([]byte("x")) || string(tree.Best()) != "a" {
+		t.Fatalf("expected the tree to be unchanged, the tip is %s", tree.Best())
+	}
+	if branch := tree.Branch([]byte("x")); branch != nil {
+		t.Fatalf("expected no branch for an unknown block, got %s", hashes(branch))
+	}
+}
+
+func TestNewReorg(t *testing.T) {
+	tree := newTestTree()
+	addBlock(t, tree, "g", "", 1)
+	addBlock(t, tree, "a", "g", 1)
+	addBlock(t, tree, "b", "a", 1)
+	addBlock(t, tree, "c", "a", 1)
+	addBlock(t, tree, "d", "c", 1)
+
+	tests := []struct {
+		name         string
+		before       string
+		after        string
+		forkHeight   int64
+		disconnected string
+		connected    string
+	}{
+		{"extension", "a", "b", 2, "", "b"},
+		{"switch", "b", "d", 2, "b", "c,d"},
+		{"from genesis", "g", "d", 1, "", "a,c,d"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reorg := NewReorg(tree.Branch([]byte(test.before)), tree.Branch([]byte(test.after)))
+			if reorg.ForkHeight != test.forkHeight {
+				t.Fatalf("expected fork height %d, got %d", test.forkHeight, reorg.ForkHeight)
+			}
+			if disconnected := hashes(reorg.Disconnected); disconnected != test.disconnected {
+				t.Fatalf("expected %q to be disconnected, got %q", test.disconnected, disconnected)
+			}
+			if connected := hashes(reorg.Connected); connected != test.connected {
+				t.Fatalf("expected %q to be connected, got %q", test.connected, connected)
+			}
+		})
+	}
+}
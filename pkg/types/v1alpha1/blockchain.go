@@ -16,27 +16,268 @@
 package v1alpha1
 
 import (
+	"bytes"
+	"errors"
+	"math/big"
+
 	"github.com/golang/glog"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// Blockchain is our internal blockchain implementation.
+// DefaultChainName is the name of the blockchain blocks are added to
+// when they do not name one explicitly.
+const DefaultChainName = "default"
+
+const (
+	// ConsensusProofOfWork seals blocks by mining a hash below the difficulty target.
+	ConsensusProofOfWork = "ProofOfWork"
+	// ConsensusProofOfAuthority seals blocks by having an authorized signer sign them.
+	ConsensusProofOfAuthority = "ProofOfAuthority"
+)
+
+// Blockchain is a named chain of blocks.
 type Blockchain struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              BlockchainSpec   `json:"spec"`
+	Status            BlockchainStatus `json:"status,omitempty"`
+
+	// Chain holds the blocks of the blockchain in memory, it is rebuilt
+	// by the controller and never persisted.
+	Chain []*Block `json:"-"`
+}
+
+// BlockchainSpec provides specifications for the blockchain.
+type BlockchainSpec struct {
+	// Description is a free-form description of what the chain is used for.
+	Description string `json:"description,omitempty"`
+	// Difficulty is the number of leading zero bits required from the hashes
+	// of new blocks, DefaultDifficulty is used when it is not set. When the
+	// blockchain retargets its difficulty this is the difficulty of the first
+	// blocks only.
+	Difficulty int64 `json:"difficulty,omitempty"`
+	// Retarget, when set, adjusts the difficulty toward a target block interval.
+	Retarget *RetargetPolicy `json:"retarget,omitempty"`
+	// Consensus is the algorithm sealing and verifying the blocks of the chain,
+	// ConsensusProofOfWork is used when it is not set.
+	Consensus string `json:"consensus,omitempty"`
+	// Authority configures the signers of a ConsensusProofOfAuthority chain.
+	Authority *AuthorityPolicy `json:"authority,omitempty"`
+	// Ledger is the model of the state updated by the transactions of the chain, e.g.
+	// LedgerUTXO. When it is not set transactions are free-form entries.
+	Ledger string `json:"ledger,omitempty"`
+	// Reward is the value paid to whoever mines a block of a ledger chain,
+	// DefaultReward is used when it is not set.
+	Reward int64 `json:"reward,omitempty"`
+	// DeletionPolicy is whether mined blocks of the chain may be deleted,
+	// DeletionPolicyRetain is used when it is not set.
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
+}
+
+const (
+	// DeletionPolicyRetain keeps the mined blocks of the chain, deleting them is rejected.
+	DeletionPolicyRetain = "Retain"
+	// DeletionPolicyAllowPrune lets mined blocks be deleted, leaving a tombstone in the
+	// status of the chain.
+	DeletionPolicyAllowPrune = "AllowPrune"
+)
+
+// AllowsPruning reports whether the mined blocks of the blockchain may be deleted.
+// Blocks of ledger chains are always retained, their ledger is replayed from the
+// transactions of every block.
+func (bc *Blockchain) AllowsPruning() bool {
+	return bc.Spec.DeletionPolicy == DeletionPolicyAllowPrune && bc.Spec.Ledger == ""
+}
+
+const (
+	// LedgerUTXO tracks value as the unspent outputs of the transactions of the chain.
+	LedgerUTXO = "UTXO"
+	// LedgerAccount tracks value as the balances of accounts.
+	LedgerAccount = "Account"
+)
+
+// DefaultReward is the value paid for a block when the blockchain does not set one.
+const DefaultReward = 50
+
+// BlockReward returns the value paid to whoever mines a block of the blockchain.
+func (bc *Blockchain) BlockReward() int64 {
+	if bc.Spec.Reward == 0 {
+		return DefaultReward
+	}
+	return bc.Spec.Reward
+}
+
+// AuthorityPolicy declares who may sign the blocks of a proof of authority chain.
+type AuthorityPolicy struct {
+	// Signers is the set of authorized signers. Signers are rotated in and out
+	// by bounding the heights they may sign at.
+	Signers []AuthoritySigner `json:"signers"`
+	// SignerSecret is the name of the Secret, in the namespace of the blockchain,
+	// holding the ed25519 private key the controller signs blocks with under the
+	// "privateKey" entry. Without it the controller only verifies blocks.
+	SignerSecret string `json:"signerSecret,omitempty"`
+}
+
+// AuthoritySigner is a signer authorized to sign blocks within a range of heights.
+type AuthoritySigner struct {
+	// Name identifies the signer in the headers of the blocks it signs.
+	Name string `json:"name"`
+	// PublicKey is the ed25519 public key of the signer.
+	PublicKey []byte `json:"publicKey"`
+	// FromHeight is the first height the signer may sign at.
+	FromHeight int64 `json:"fromHeight,omitempty"`
+	// UntilHeight, when set, is the first height the signer may no longer sign at.
+	UntilHeight int64 `json:"untilHeight,omitempty"`
+}
+
+// AuthorizedAt reports whether the signer may sign the block at the given height.
+func (s *AuthoritySigner) AuthorizedAt(height int64) bool {
+	return height >= s.FromHeight && (s.UntilHeight == 0 || height < s.UntilHeight)
+}
+
+// BlockchainStatus is the observed state of the blockchain, as set by the controller.
+type BlockchainStatus struct {
+	// Height is the height of the tip of the chain, the genesis block has height 0.
+	Height int64 `json:"height"`
+	// TipHash is the hash of the last block in the chain.
+	TipHash []byte `json:"tipHash,omitempty"`
+	// Difficulty is the number of leading zero bits required from block hashes.
+	Difficulty int64 `json:"difficulty,omitempty"`
+	// TotalWork is the expected number of hashes needed to produce the chain, in decimal.
+	TotalWork string `json:"totalWork,omitempty"`
+	// Accounts are the accounts of a LedgerAccount chain at its tip, ordered by address.
+	Accounts []AccountStatus `json:"accounts,omitempty"`
+	// Tombstones record the mined blocks which were deleted from the chain, ordered by deletion.
+	Tombstones         []BlockTombstone `json:"tombstones,omitempty"`
+	ObservedGeneration int64            `json:"observedGeneration,omitempty"`
+}
+
+// BlockTombstone records a mined block which was deleted from a blockchain allowing
+// pruning. It keeps the header of the block, so the blocks after it still link to it
+// and its seal can still be verified, only its transactions are gone.
+type BlockTombstone struct {
+	// Name is the name the block had.
+	Name   string `json:"name"`
+	Hash   []byte `json:"hash"`
+	Height int64  `json:"height"`
+	// Deleter is the user who deleted the block, see DeletedByAnnotation.
+	Deleter           string      `json:"deleter,omitempty"`
+	DeletionTimestamp metav1.Time `json:"deletionTimestamp,omitempty"`
+	// Header is the spec of the block without its transactions. Blocks sealed before
+	// merkle roots were introduced keep their data, which their hash commits to.
+	Header BlockSpec `json:"header"`
+}
+
+// NewBlockTombstone returns the tombstone of a mined block deleted by deleter.
+func NewBlockTombstone(block *Block, deleter string) BlockTombstone {
+	tombstone := BlockTombstone{
+		Name:    block.Name,
+		Hash:    copyBytes(block.Spec.Hash),
+		Height:  block.Status.Height,
+		Deleter: deleter,
+	}
+	if block.DeletionTimestamp != nil {
+		tombstone.DeletionTimestamp = *block.DeletionTimestamp
+	}
+	block.Spec.DeepCopyInto(&tombstone.Header)
+	tombstone.Header.Transactions = nil
+	if len(tombstone.Header.MerkleRoot) > 0 {
+		tombstone.Header.Data = ""
+	}
+	return tombstone
+}
+
+// Block returns the header of the pruned block as a block in the BlockPruned phase,
+// e.g. to connect the blocks after it when the blockchain is recovered.
+func (t *BlockTombstone) Block(namespace string) *Block {
+	block := &Block{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: t.Name},
+		Status:     BlockStatus{Phase: BlockPruned, Height: t.Height},
+	}
+	t.Header.DeepCopyInto(&block.Spec)
+	return block
+}
+
+// Tombstone returns the tombstone of the block with the given hash, or nil if it was not pruned.
+func (bc *Blockchain) Tombstone(hash []byte) *BlockTombstone {
+	for i := range bc.Status.Tombstones {
+		if bytes.Equal(bc.Status.Tombstones[i].Hash, hash) {
+			return &bc.Status.Tombstones[i]
+		}
+	}
+	return nil
+}
+
+// AccountStatus is the state of an account of a LedgerAccount chain.
+type AccountStatus struct {
+	Address string `json:"address"`
+	Balance int64  `json:"balance"`
+	// Nonce is the number of transfers sent from the account, the next transfer must carry it.
+	Nonce uint64 `json:"nonce"`
+}
+
+// BlockchainList is a list of blockchains.
+type BlockchainList struct {
 	metav1.TypeMeta `json:",inline"`
 	metav1.ListMeta `json:"metadata,omitempty"`
 
-	Chain []*Block `json:"chain"`
+	Items []Blockchain `json:"items"`
+}
+
+// ErrTipMoved is returned when a block is added on top of a block
+// which is no longer the tip of the blockchain.
+var ErrTipMoved = errors.New("the tip of the blockchain moved while the block was mined")
+
+// NextDifficulty returns the difficulty required from the next block.
+func (bc *Blockchain) NextDifficulty() int64 {
+	return bc.RequiredDifficulty(int64(len(bc.Chain)))
 }
 
-// AddBlock adds a new block to the blockchain.
-func (bc *Blockchain) AddBlock(block *Block) {
-	glog.Infof("Adding new block...")
+// Tip returns the hash of the last block in the chain and the height the
+// next block will be added at. The hash is empty for an empty chain.
+func (bc *Blockchain) Tip() ([]byte, int64) {
 	if len(bc.Chain) == 0 {
-		chain := []*Block{block}
-		bc.Chain = chain
-	} else {
-		prevBlock := bc.Chain[len(bc.Chain)-1]
-		block.Spec.PrevBlockHash = prevBlock.Spec.Hash
-		bc.Chain = append(bc.Chain, block)
+		return nil, 0
+	}
+	return bc.Chain[len(bc.Chain)-1].Spec.Hash, int64(len(bc.Chain))
+}
+
+// AddBlock adds a new sealed block to the blockchain and returns its height.
+// The block must link to the current tip, otherwise ErrTipMoved is returned
+// and the block has to be sealed again. Verifying the seal is left to the caller.
+func (bc *Blockchain) AddBlock(block *Block) (int64, error) {
+	if err := bc.ValidateLink(block); err != nil {
+		return 0, err
+	}
+	_, height := bc.Tip()
+	glog.Infof("Adding new block to blockchain %s at height %d...", bc.Name, height)
+	bc.Chain = append(bc.Chain, block)
+	return height, nil
+}
+
+// ValidateLink checks that block links to the tip of the chain.
+func (bc *Blockchain) ValidateLink(block *Block) error {
+	tip, _ := bc.Tip()
+	if !bytes.Equal(block.Spec.PrevBlockHash, tip) {
+		return ErrTipMoved
+	}
+	return nil
+}
+
+// ComputeStatus returns the status describing the blocks currently held in memory,
+// weight returns the work each block adds to the chain under its consensus.
+func (bc *Blockchain) ComputeStatus(weight func(*Block) *big.Int) BlockchainStatus {
+	status := BlockchainStatus{Difficulty: bc.NextDifficulty()}
+
+	totalWork := new(big.Int)
+	for _, block := range bc.Chain {
+		totalWork.Add(totalWork, weight(block))
+	}
+	status.TotalWork = totalWork.String()
+
+	if len(bc.Chain) > 0 {
+		status.Height = int64(len(bc.Chain) - 1)
+		status.TipHash = bc.Chain[len(bc.Chain)-1].Spec.Hash
 	}
+	return status
 }
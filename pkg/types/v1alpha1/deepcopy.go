@@ -22,17 +22,80 @@ import (
 // DeepCopyInto copies infromation from one (pointer of) block to another.
 func (in *Block) DeepCopyInto(out *Block) {
 	out.TypeMeta = in.TypeMeta
-	out.ObjectMeta = in.ObjectMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopyInto copies the spec of a block, including its transactions.
+func (in *BlockSpec) DeepCopyInto(out *BlockSpec) {
+	*out = BlockSpec{
+		Chain:         in.Chain,
+		Timestamp:     in.Timestamp,
+		Data:          in.Data,
+		MerkleRoot:    copyBytes(in.MerkleRoot),
+		PrevBlockHash: copyBytes(in.PrevBlockHash),
+		Hash:          copyBytes(in.Hash),
+		Nonce:         in.Nonce,
+		Difficulty:    in.Difficulty,
+		Signer:        in.Signer,
+		Signature:     copyBytes(in.Signature),
+	}
+	if in.Transactions != nil {
+		out.Transactions = make([]Transaction, len(in.Transactions))
+		for i := range in.Transactions {
+			in.Transactions[i].DeepCopyInto(&out.Transactions[i])
+		}
+	}
+}
 
-	out.Spec = BlockSpec{
-		Timestamp:     in.Spec.Timestamp,
-		Data:          in.Spec.Data,
-		PrevBlockHash: in.Spec.PrevBlockHash,
-		Hash:          in.Spec.Hash,
-		Nonce:         in.Spec.Nonce,
+// DeepCopyInto copies a transaction.
+func (in *Transaction) DeepCopyInto(out *Transaction) {
+	*out = *in
+	out.ID = copyBytes(in.ID)
+	if in.Inputs != nil {
+		out.Inputs = make([]TxInput, len(in.Inputs))
+		for i, input := range in.Inputs {
+			input.TxID = copyBytes(input.TxID)
+			input.Signature = copyBytes(input.Signature)
+			input.PubKey = copyBytes(input.PubKey)
+			out.Inputs[i] = input
+		}
+	}
+	if in.Outputs != nil {
+		out.Outputs = make([]TxOutput, len(in.Outputs))
+		copy(out.Outputs, in.Outputs)
+	}
+	if in.Transfer != nil {
+		transfer := *in.Transfer
+		transfer.PubKey = copyBytes(in.Transfer.PubKey)
+		transfer.Signature = copyBytes(in.Transfer.Signature)
+		out.Transfer = &transfer
 	}
 }
 
+// DeepCopyInto copies all the status fields of a block, including its conditions.
+func (in *BlockStatus) DeepCopyInto(out *BlockStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		out.Conditions = make([]BlockCondition, len(in.Conditions))
+		for i := range in.Conditions {
+			out.Conditions[i] = in.Conditions[i]
+			in.Conditions[i].LastTransitionTime.DeepCopyInto(&out.Conditions[i].LastTransitionTime)
+		}
+	}
+}
+
+// DeepCopy returns a deep copy of the block.
+func (in *Block) DeepCopy() *Block {
+	if in == nil {
+		return nil
+	}
+	out := new(Block)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyObject returns a generically typed copy of an object
 func (in *Block) DeepCopyObject() runtime.Object {
 	out := Block{}
@@ -47,7 +110,130 @@ func (in *BlockList) DeepCopyObject() runtime.Object {
 	out.ListMeta = in.ListMeta
 	out.Items = make([]Block, len(in.Items))
 	for idx := range in.Items {
-		out.Items[idx].DeepCopyInto(&out.Items[idx])
+		in.Items[idx].DeepCopyInto(&out.Items[idx])
+	}
+	return &out
+}
+
+// DeepCopyInto copies infromation from one (pointer of) blockchain to another.
+func (in *Blockchain) DeepCopyInto(out *Blockchain) {
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	if in.Spec.Retarget != nil {
+		retarget := *in.Spec.Retarget
+		out.Spec.Retarget = &retarget
+	}
+	if in.Spec.Authority != nil {
+		authority := *in.Spec.Authority
+		authority.Signers = make([]AuthoritySigner, len(in.Spec.Authority.Signers))
+		for i, signer := range in.Spec.Authority.Signers {
+			signer.PublicKey = copyBytes(signer.PublicKey)
+			authority.Signers[i] = signer
+		}
+		out.Spec.Authority = &authority
+	}
+	out.Status = in.Status
+	out.Status.TipHash = copyBytes(in.Status.TipHash)
+	if in.Status.Accounts != nil {
+		out.Status.Accounts = make([]AccountStatus, len(in.Status.Accounts))
+		copy(out.Status.Accounts, in.Status.Accounts)
+	}
+	if in.Status.Tombstones != nil {
+		out.Status.Tombstones = make([]BlockTombstone, len(in.Status.Tombstones))
+		for i := range in.Status.Tombstones {
+			in.Status.Tombstones[i].DeepCopyInto(&out.Status.Tombstones[i])
+		}
+	}
+	if in.Chain != nil {
+		out.Chain = make([]*Block, len(in.Chain))
+		for i := range in.Chain {
+			out.Chain[i] = in.Chain[i].DeepCopy()
+		}
+	}
+}
+
+// DeepCopyInto copies a tombstone, including the header of the block.
+func (in *BlockTombstone) DeepCopyInto(out *BlockTombstone) {
+	*out = *in
+	out.Hash = copyBytes(in.Hash)
+	in.DeletionTimestamp.DeepCopyInto(&out.DeletionTimestamp)
+	in.Header.DeepCopyInto(&out.Header)
+}
+
+// DeepCopy returns a deep copy of the blockchain.
+func (in *Blockchain) DeepCopy() *Blockchain {
+	if in == nil {
+		return nil
+	}
+	out := new(Blockchain)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject returns a generically typed copy of an object
+func (in *Blockchain) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+// DeepCopyObject returns a generically typed copy of an object
+func (in *BlockchainList) DeepCopyObject() runtime.Object {
+	out := BlockchainList{}
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	out.Items = make([]Blockchain, len(in.Items))
+	for idx := range in.Items {
+		in.Items[idx].DeepCopyInto(&out.Items[idx])
 	}
 	return &out
 }
+
+// DeepCopyInto copies infromation from one (pointer of) transaction request to another.
+func (in *TransactionRequest) DeepCopyInto(out *TransactionRequest) {
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	if in.Spec.Inputs != nil || in.Spec.Outputs != nil || in.Spec.Transfer != nil {
+		var tx Transaction
+		(&Transaction{Inputs: in.Spec.Inputs, Outputs: in.Spec.Outputs, Transfer: in.Spec.Transfer}).DeepCopyInto(&tx)
+		out.Spec.Inputs, out.Spec.Outputs, out.Spec.Transfer = tx.Inputs, tx.Outputs, tx.Transfer
+	}
+	out.Status = in.Status
+	out.Status.ID = copyBytes(in.Status.ID)
+}
+
+// DeepCopy returns a deep copy of the transaction request.
+func (in *TransactionRequest) DeepCopy() *TransactionRequest {
+	if in == nil {
+		return nil
+	}
+	out := new(TransactionRequest)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject returns a generically typed copy of an object
+func (in *TransactionRequest) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+// DeepCopyObject returns a generically typed copy of an object
+func (in *TransactionRequestList) DeepCopyObject() runtime.Object {
+	out := TransactionRequestList{}
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	out.Items = make([]TransactionRequest, len(in.Items))
+	for idx := range in.Items {
+		in.Items[idx].DeepCopyInto(&out.Items[idx])
+	}
+	return &out
+}
+
+func copyBytes(in []byte) []byte {
+	if in == nil {
+		return nil
+	}
+	out := make([]byte, len(in))
+	copy(out, in)
+	return out
+}
@@ -0,0 +1,148 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// Prefixes distinguishing the hashes of leaves from the hashes of inner nodes,
+// so an inner node can never be passed off as a transaction.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// MerkleTree is a binary hash tree over the transactions of a block. A node
+// without a sibling is promoted to the next level unchanged.
+type MerkleTree struct {
+	// levels holds the hashes of every level, from the leaves up to the root.
+	levels [][][]byte
+}
+
+// NewMerkleTree builds the merkle tree over the given transaction hashes.
+func NewMerkleTree(leaves [][]byte) *MerkleTree {
+	level := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = hashMerkleLeaf(leaf)
+	}
+	tree := &MerkleTree{levels: [][][]byte{level}}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, hashMerkleNode(level[i], level[i+1]))
+		}
+		tree.levels = append(tree.levels, next)
+		level = next
+	}
+	return tree
+}
+
+// Root returns the merkle root, it is empty for a tree without leaves.
+func (t *MerkleTree) Root() []byte {
+	top := t.levels[len(t.levels)-1]
+	if len(top) == 0 {
+		return nil
+	}
+	return top[0]
+}
+
+// Proof returns the path from the leaf at index up to the root, see MerkleProof.
+func (t *MerkleTree) Proof(index int) ([]MerkleProofStep, error) {
+	if index < 0 || index >= len(t.levels[0]) {
+		return nil, fmt.Errorf("leaf %d is out of range, the tree has %d leaves", index, len(t.levels[0]))
+	}
+	var path []MerkleProofStep
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := index ^ 1
+		// A node without a sibling is promoted unchanged.
+		if sibling < len(level) {
+			path = append(path, MerkleProofStep{Hash: level[sibling], Left: sibling < index})
+		}
+		index /= 2
+	}
+	return path, nil
+}
+
+// merkleIndex returns the index of the leaf path starts from in a tree of the given
+// number of leaves, it fails when path does not fit such a tree. Walking down from the
+// root, a node is the last of an odd level and was promoted without a sibling, or it
+// has one and the side of its sibling on path tells which child it is.
+func merkleIndex(path []MerkleProofStep, leaves int) (int, bool) {
+	if leaves <= 0 {
+		return 0, false
+	}
+	widths := []int{leaves}
+	for width := leaves; width > 1; {
+		width = (width + 1) / 2
+		widths = append(widths, width)
+	}
+
+	index, step := 0, len(path)
+	for level := len(widths) - 2; level >= 0; level-- {
+		index *= 2
+		if index == widths[level]-1 {
+			continue
+		}
+		step--
+		if step < 0 {
+			return 0, false
+		}
+		if path[step].Left {
+			index++
+		}
+	}
+	return index, step == 0
+}
+
+// MerkleProofStep is a sibling hash on the path from a leaf up to the merkle root.
+type MerkleProofStep struct {
+	Hash []byte `json:"hash"`
+	// Left is set when the sibling is the left child of their parent.
+	Left bool `json:"left,omitempty"`
+}
+
+// MerkleRootFromPath returns the merkle root the leaf hashes up to through path.
+func MerkleRootFromPath(leaf []byte, path []MerkleProofStep) []byte {
+	hash := hashMerkleLeaf(leaf)
+	for _, step := range path {
+		if step.Left {
+			hash = hashMerkleNode(step.Hash, hash)
+		} else {
+			hash = hashMerkleNode(hash, step.Hash)
+		}
+	}
+	return hash
+}
+
+func hashMerkleLeaf(leaf []byte) []byte {
+	hash := sha256.Sum256(append([]byte{merkleLeafPrefix}, leaf...))
+	return hash[:]
+}
+
+func hashMerkleNode(left, right []byte) []byte {
+	data := make([]byte, 0, 1+len(left)+len(right))
+	data = append(data, merkleNodePrefix)
+	data = append(data, left...)
+	data = append(data, right...)
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
@@ -0,0 +1,156 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// ErrInvalidMerkleProof is returned when a merkle proof does not prove its transaction is part of its block.
+var ErrInvalidMerkleProof = errors.New("the merkle proof does not match the block")
+
+// MerkleProof proves a transaction is part of a mined block without the other
+// transactions of the block: the transaction hashes up to the merkle root through
+// Path, and the header carrying the merkle root hashes to the block hash. The proof
+// is only as trustworthy as the block hash it is verified against.
+type MerkleProof struct {
+	// Consensus is the consensus of the blockchain, it defines how the header is hashed.
+	Consensus string `json:"consensus"`
+	// Header is the spec of the block without its transactions.
+	Header BlockSpec `json:"header"`
+	// Height is the height of the block on the main chain of its blockchain. The header
+	// does not commit to it, it is informational only.
+	Height      int64       `json:"height"`
+	Transaction Transaction `json:"transaction"`
+	// Index is the position of the transaction in the block, it must match the
+	// position Path leads to in a tree of Leaves leaves.
+	Index int `json:"index"`
+	// Leaves is the number of transactions of the block, which the header does not
+	// commit to either.
+	Leaves int               `json:"leaves"`
+	Path   []MerkleProofStep `json:"path"`
+}
+
+// NewMerkleProof returns the proof that the transaction at index is part of the
+// mined block, consensus is the consensus of the blockchain of the block. Blocks
+// which are not on the main chain of their blockchain cannot be proven.
+func NewMerkleProof(block *Block, consensus string, index int) (*MerkleProof, error) {
+	if block.Status.Phase != BlockMined || len(block.Spec.Hash) == 0 {
+		return nil, fmt.Errorf("block %s/%s is not mined", block.Namespace, block.Name)
+	}
+	if len(block.Spec.MerkleRoot) == 0 {
+		return nil, fmt.Errorf("block %s/%s was sealed without a merkle root", block.Namespace, block.Name)
+	}
+
+	transactions := block.Transactions()
+	leaves := make([][]byte, len(transactions))
+	for i := range transactions {
+		leaves[i] = transactions[i].Hash()
+	}
+	path, err := NewMerkleTree(leaves).Proof(index)
+	if err != nil {
+		return nil, err
+	}
+
+	header := block.DeepCopy().Spec
+	header.Data = ""
+	header.Transactions = nil
+	return &MerkleProof{
+		Consensus:   consensus,
+		Header:      header,
+		Height:      block.Status.Height,
+		Transaction: transactions[index],
+		Index:       index,
+		Leaves:      len(leaves),
+		Path:        path,
+	}, nil
+}
+
+// Verify checks the proof is for the block with the expected hash, which the caller
+// must trust, e.g. read from its own view of the blockchain. The transaction must hash
+// up to the merkle root of the header at Index and the header to the block hash. For
+// proof of work blocks the hash must also meet the difficulty of the block, proof of
+// authority blocks must be signed by one of the signers of authority.
+func (p *MerkleProof) Verify(expected []byte, authority *AuthorityPolicy) error {
+	if len(expected) == 0 {
+		return fmt.Errorf("the hash of the block the proof is expected for is required")
+	}
+	if !bytes.Equal(expected, p.Header.Hash) {
+		return fmt.Errorf("the proof is for block %x, not %x", p.Header.Hash, expected)
+	}
+
+	leaf := p.Transaction.Hash()
+	if len(p.Transaction.ID) > 0 && !bytes.Equal(p.Transaction.ID, leaf) {
+		return ErrInvalidMerkleProof
+	}
+	if !bytes.Equal(MerkleRootFromPath(leaf, p.Path), p.Header.MerkleRoot) {
+		return ErrInvalidMerkleProof
+	}
+	// A path through promoted nodes also fits trees of other sizes, where it leads to
+	// another index, so Index is checked against the tree of Leaves leaves.
+	if index, ok := merkleIndex(p.Path, p.Leaves); !ok || index != p.Index {
+		return ErrInvalidMerkleProof
+	}
+
+	header := &Block{Spec: p.Header}
+	hash, err := header.SealHash(p.Consensus)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash, p.Header.Hash) {
+		return ErrInvalidMerkleProof
+	}
+	switch p.Consensus {
+	case "", ConsensusProofOfWork:
+		if !NewProofOfWork(header).Validate() {
+			return ErrInvalidMerkleProof
+		}
+	case ConsensusProofOfAuthority:
+		return p.verifySigner(hash, authority)
+	}
+	return nil
+}
+
+// verifySigner checks the signature of the header was made by the signer it names,
+// which must be one of the signers of authority. The header does not commit to the
+// height of the block, so the heights a signer is authorized at are not checked, the
+// block hash the caller trusts already places the block on its chain.
+func (p *MerkleProof) verifySigner(hash []byte, authority *AuthorityPolicy) error {
+	if authority == nil {
+		return fmt.Errorf("the signers of the blockchain are required to verify a proof of authority block")
+	}
+	known := false
+	// A signer may be declared several times, e.g. with a rotated key.
+	for i := range authority.Signers {
+		signer := &authority.Signers[i]
+		if signer.Name != p.Header.Signer {
+			continue
+		}
+		known = true
+		if len(signer.PublicKey) == ed25519.PublicKeySize &&
+			ed25519.Verify(ed25519.PublicKey(signer.PublicKey), hash, p.Header.Signature) {
+			return nil
+		}
+	}
+	if known {
+		return ErrInvalidMerkleProof
+	}
+	return fmt.Errorf("signer %q is not a signer of the blockchain", p.Header.Signer)
+}
@@ -0,0 +1,199 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// minedBlock returns a mined proof of work block at height 3 carrying the given entries.
+func minedBlock(t *testing.T, entries ...string) *Block {
+	block := &Block{Spec: BlockSpec{PrevBlockHash: []byte("parent"), Difficulty: MinDifficulty}}
+	for _, entry := range entries {
+		block.Spec.Transactions = append(block.Spec.Transactions, Transaction{Data: entry})
+	}
+	block.SetMerkleRoot()
+	if err := block.Process(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	block.Status.Phase = BlockMined
+	block.Status.Height = 3
+	return block
+}
+
+// signedBlock returns a mined proof of authority block at height 3 signed by key.
+func signedBlock(signer string, key ed25519.PrivateKey, entries ...string) *Block {
+	block := &Block{Spec: BlockSpec{PrevBlockHash: []byte("parent"), Signer: signer}}
+	for _, entry := range entries {
+		block.Spec.Transactions = append(block.Spec.Transactions, Transaction{Data: entry})
+	}
+	block.SetMerkleRoot()
+	block.Spec.Hash = block.AuthorityHash()
+	block.Spec.Signature = ed25519.Sign(key, block.Spec.Hash)
+	block.Status.Phase = BlockMined
+	block.Status.Height = 3
+	return block
+}
+
+func TestMerkleTreeProof(t *testing.T) {
+	for leaves := 1; leaves <= 9; leaves++ {
+		var data [][]byte
+		for i := 0; i < leaves; i++ {
+			data = append(data, []byte(fmt.Sprintf("tx-%d", i)))
+		}
+		tree := NewMerkleTree(data)
+		for i, leaf := range data {
+			path, err := tree.Proof(i)
+			if err != nil {
+				t.Fatalf("leaf %d of %d: %v", i, leaves, err)
+			}
+			if root := MerkleRootFromPath(leaf, path); !bytes.Equal(root, tree.Root()) {
+				t.Fatalf("leaf %d of %d: expected root %x, got %x", i, leaves, tree.Root(), root)
+			}
+			if root := MerkleRootFromPath([]byte("forged"), path); bytes.Equal(root, tree.Root()) {
+				t.Fatalf("leaf %d of %d: a forged leaf hashes up to the root", i, leaves)
+			}
+			if index, ok := merkleIndex(path, leaves); !ok || index != i {
+				t.Fatalf("leaf %d of %d: expected the path to lead to it, got %d, %t", i, leaves, index, ok)
+			}
+		}
+		if _, err := tree.Proof(leaves); err == nil {
+			t.Fatalf("expected an error for leaf %d of %d", leaves, leaves)
+		}
+	}
+}
+
+func TestMerkleIndex(t *testing.T) {
+	// The path of the last of five leaves, promoted twice, also fits two leaves.
+	path := []MerkleProofStep{{Hash: []byte("x"), Left: true}}
+	if index, ok := merkleIndex(path, 5); !ok || index != 4 {
+		t.Fatalf("expected leaf 4 of 5, got %d, %t", index, ok)
+	}
+	if index, ok := merkleIndex(path, 2); !ok || index != 1 {
+		t.Fatalf("expected leaf 1 of 2, got %d, %t", index, ok)
+	}
+	if _, ok := merkleIndex(path, 4); ok {
+		t.Fatal("expected a single step not to fit a tree of 4 leaves")
+	}
+}
+
+func TestNewMerkleProof(t *testing.T) {
+	block := minedBlock(t, "a", "b", "c")
+	tests := []struct {
+		name  string
+		phase BlockPhase
+		index int
+		valid bool
+	}{
+		{"mined", BlockMined, 1, true},
+		{"last entry", BlockMined, 2, true},
+		{"stale", BlockStale, 1, false},
+		{"orphan", BlockOrphan, 1, false},
+		{"pending", BlockPending, 1, false},
+		{"out of range", BlockMined, 3, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			block := block.DeepCopy()
+			block.Status.Phase = test.phase
+			_, err := NewMerkleProof(block, ConsensusProofOfWork, test.index)
+			if valid := err == nil; valid != test.valid {
+				t.Fatalf("expected the proof to be valid: %t, got %v", test.valid, err)
+			}
+		})
+	}
+}
+
+func TestMerkleProofVerify(t *testing.T) {
+	block := minedBlock(t, "a", "b", "c", "d", "e")
+	tests := []struct {
+		name     string
+		expected []byte
+		tamper   func(*MerkleProof)
+		valid    bool
+	}{
+		{"valid", block.Spec.Hash, func(*MerkleProof) {}, true},
+		{"no expected hash", nil, func(*MerkleProof) {}, false},
+		{"other block", []byte("other"), func(*MerkleProof) {}, false},
+		{"tampered transaction", block.Spec.Hash, func(p *MerkleProof) { p.Transaction.Data = "x" }, false},
+		{"tampered path", block.Spec.Hash, func(p *MerkleProof) { p.Path[0].Hash = []byte("x") }, false},
+		{"tampered index", block.Spec.Hash, func(p *MerkleProof) { p.Index = 2 }, false},
+		{"index out of range", block.Spec.Hash, func(p *MerkleProof) { p.Index = 7 }, false},
+		{"tampered leaves", block.Spec.Hash, func(p *MerkleProof) { p.Leaves = 2 }, false},
+		{"no leaves", block.Spec.Hash, func(p *MerkleProof) { p.Leaves = 0 }, false},
+		{"tampered height", block.Spec.Hash, func(p *MerkleProof) { p.Height = 100 }, true},
+		{"tampered header", block.Spec.Hash, func(p *MerkleProof) { p.Header.Timestamp++ }, false},
+		{"invalid difficulty", block.Spec.Hash, func(p *MerkleProof) { p.Header.Difficulty = MaxDifficulty + 1 }, false},
+		{"unknown consensus", block.Spec.Hash, func(p *MerkleProof) { p.Consensus = "Unknown" }, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proof, err := NewMerkleProof(block, ConsensusProofOfWork, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.tamper(proof)
+			err = proof.Verify(test.expected, nil)
+			if valid := err == nil; valid != test.valid {
+				t.Fatalf("expected the proof to be valid: %t, got %v", test.valid, err)
+			}
+		})
+	}
+}
+
+func TestMerkleProofVerifySigner(t *testing.T) {
+	public, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublic, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := signedBlock("alice", key, "a", "b")
+
+	tests := []struct {
+		name      string
+		authority *AuthorityPolicy
+		valid     bool
+	}{
+		{"authorized", &AuthorityPolicy{Signers: []AuthoritySigner{{Name: "alice", PublicKey: public}}}, true},
+		{"no signers", nil, false},
+		{"unknown signer", &AuthorityPolicy{Signers: []AuthoritySigner{{Name: "bob", PublicKey: public}}}, false},
+		{"other key", &AuthorityPolicy{Signers: []AuthoritySigner{{Name: "alice", PublicKey: otherPublic}}}, false},
+		{"rotated key", &AuthorityPolicy{Signers: []AuthoritySigner{
+			{Name: "alice", PublicKey: otherPublic, UntilHeight: 2},
+			{Name: "alice", PublicKey: public, FromHeight: 2},
+		}}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proof, err := NewMerkleProof(block, ConsensusProofOfAuthority, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = proof.Verify(block.Spec.Hash, test.authority)
+			if valid := err == nil; valid != test.valid {
+				t.Fatalf("expected the proof to be valid: %t, got %v", test.valid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMerkleTreeRoot(t *testing.T) {
+	a, b, c := hashMerkleLeaf([]byte("a")), hashMerkleLeaf([]byte("b")), hashMerkleLeaf([]byte("c"))
+	tests := []struct {
+		name     string
+		leaves   []string
+		expected []byte
+	}{
+		{"no leaves", nil, nil},
+		{"one leaf", []string{"a"}, a},
+		{"two leaves", []string{"a", "b"}, hashMerkleNode(a, b)},
+		// The last node of an odd level is promoted rather than paired with itself.
+		{"odd leaves", []string{"a", "b", "c"}, hashMerkleNode(hashMerkleNode(a, b), c)},
+		{"four leaves", []string{"a", "b", "c", "a"}, hashMerkleNode(hashMerkleNode(a, b), hashMerkleNode(c, a))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var leaves [][]byte
+			for _, leaf := range test.leaves {
+				leaves = append(leaves, []byte(leaf))
+			}
+			if root := NewMerkleTree(leaves).Root(); !bytes.Equal(root, test.expected) {
+				t.Fatalf("expected root %x, got %x", test.expected, root)
+			}
+		})
+	}
+}
+
+func TestMerkleRootSecondPreimage(t *testing.T) {
+	// A leaf holding the concatenation of two leaf hashes does not hash to their parent.
+	a, b := hashMerkleLeaf([]byte("a")), hashMerkleLeaf([]byte("b"))
+	forged := NewMerkleTree([][]byte{append(append([]byte{}, a...), b...)}).Root()
+	if bytes.Equal(forged, NewMerkleTree([][]byte{[]byte("a"), []byte("b")}).Root()) {
+		t.Fatal("expected leaves and inner nodes to be hashed apart")
+	}
+}
+
+func TestValidateMerkleRoot(t *testing.T) {
+	block := &Block{Spec: BlockSpec{Transactions: []Transaction{{Data: "a"}, {Data: "b"}}}}
+	block.SetMerkleRoot()
+	if err := block.ValidateMerkleRoot(); err != nil {
+		t.Fatalf("expected the merkle root to be valid, got %v", err)
+	}
+
+	block.Spec.Transactions[1].Data = "c"
+	if err := block.ValidateMerkleRoot(); err != ErrInvalidMerkleRoot {
+		t.Fatalf("expected error %v for a changed transaction, got %v", ErrInvalidMerkleRoot, err)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+// The number of nonces tried between checks for cancellation and
+// updates of the shared hash counter.
+const cancelCheckInterval = 1 << 12
+
+// DefaultProgressInterval is how often progress is reported when no interval is set.
+const DefaultProgressInterval = time.Second
+
+// Progress reports how far a miner got with the current block.
+type Progress struct {
+	// Hashes is the number of hashes computed for the current block so far.
+	Hashes uint64
+	// Elapsed is the time spent mining the current block so far.
+	Elapsed time.Duration
+}
+
+// Miner searches the nonce space of a block for a hash below its PoW target.
+// The hot loop precomputes the header, only rewrites the nonce bytes on every
+// iteration and compares hashes to the target as fixed-size byte arrays, so it
+// does not allocate.
+type Miner struct {
+	// hashes is the number of hashes computed over all the blocks, updated
+	// atomically. It is kept first for 64-bit alignment on 32-bit platforms.
+	hashes uint64
+
+	// Workers is the number of goroutines searching the nonce space in parallel,
+	// each of them searches its own contiguous range of nonces.
+	Workers int
+	// Progress, when set, receives the progress of the current block at most once
+	// per ProgressInterval. Sends never block the miner, updates are dropped when
+	// the channel is not ready.
+	Progress chan<- Progress
+	// ProgressInterval defaults to DefaultProgressInterval.
+	ProgressInterval time.Duration
+}
+
+type minerResult struct {
+	nonce int
+	hash  []byte
+	err   error
+}
+
+// Hashes returns the number of hashes computed by the miner over all the blocks.
+func (m *Miner) Hashes() uint64 {
+	return atomic.LoadUint64(&m.hashes)
+}
+
+// Run searches the nonce space of the block of pow and returns the first nonce
+// found yielding a valid hash along with the hash. All the workers stop as soon
+// as one of them finds a valid hash. On cancellation the number of nonces tried
+// by all the workers together is returned with the context error.
+func (m *Miner) Run(ctx context.Context, pow *ProofOfWork) (int, []byte, error) {
+	if !ValidDifficulty(pow.block.Difficulty()) {
+		return 0, nil, ErrInvalidDifficulty
+	}
+	workers := m.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	header := pow.header()
+	start := time.Now()
+	var hashes uint64
+
+	searchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan struct{})
+	reported := make(chan struct{})
+	if m.Progress != nil {
+		go func() {
+			defer close(reported)
+			m.reportProgress(done, &hashes, start)
+		}()
+	} else {
+		close(reported)
+	}
+
+	results := make(chan minerResult, workers)
+	rangeSize := maxNonce / workers
+	for i := 0; i < workers; i++ {
+		from := i * rangeSize
+		to := from + rangeSize
+		if i == workers-1 {
+			to = maxNonce
+		}
+		go func(from, to int) {
+			results <- search(searchCtx, header, &pow.targetBytes, from, to, &hashes)
+		}(from, to)
+	}
+
+	// Wait for every worker so none of them outlives the call.
+	var found *minerResult
+	for i := 0; i < workers; i++ {
+		r := <-results
+		if r.err == nil && found == nil {
+			found = &r
+			cancel()
+		}
+	}
+	// The progress channel is never sent to once Run returns.
+	close(done)
+	<-reported
+
+	tried := atomic.LoadUint64(&hashes)
+	atomic.AddUint64(&m.hashes, tried)
+
+	if found != nil {
+		return found.nonce, found.hash, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return int(tried), nil, err
+	}
+	return int(tried), nil, ErrNonceSpaceExhausted
+}
+
+// reportProgress sends the progress of the current block every interval until done is closed.
+func (m *Miner) reportProgress(done <-chan struct{}, hashes *uint64, start time.Time) {
+	interval := m.ProgressInterval
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			progress := Progress{
+				Hashes:  atomic.LoadUint64(hashes),
+				Elapsed: time.Since(start),
+			}
+			select {
+			case m.Progress <- progress:
+			default:
+			}
+		}
+	}
+}
+
+// search tries the nonces in [from, to) and returns the first one yielding a hash
+// below target. The hashes computed are added to hashes in batches.
+func search(ctx context.Context, header []byte, target *[sha256.Size]byte, from, to int, hashes *uint64) minerResult {
+	data := make([]byte, len(header)+8)
+	copy(data, header)
+	nonceBytes := data[len(header):]
+
+	var pending uint64
+	for nonce := from; nonce < to; nonce++ {
+		// Checking the context on every nonce would slow the loop down.
+		if pending == cancelCheckInterval {
+			atomic.AddUint64(hashes, pending)
+			pending = 0
+			if err := ctx.Err(); err != nil {
+				return minerResult{err: err}
+			}
+		}
+
+		binary.BigEndian.PutUint64(nonceBytes, uint64(nonce))
+		hash := sha256.Sum256(data)
+		pending++
+
+		if hashBelowTarget(&hash, target) {
+			atomic.AddUint64(hashes, pending)
+			found := make([]byte, len(hash))
+			copy(found, hash[:])
+			return minerResult{nonce: nonce, hash: found}
+		}
+	}
+
+	atomic.AddUint64(hashes, pending)
+	return minerResult{err: ErrNonceSpaceExhausted}
+}
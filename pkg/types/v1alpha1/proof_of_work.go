@@ -17,42 +17,75 @@ package v1alpha1
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
-	"fmt"
+	"errors"
 	"log"
 	"math"
 	"math/big"
+
+	"github.com/golang/glog"
 )
 
-const targetBits = 24
 const shaLength = 256
 const maxNonce = math.MaxInt64
 
+const (
+	// DefaultDifficulty is the number of leading zero bits required from block
+	// hashes when neither the block nor its blockchain set a difficulty.
+	DefaultDifficulty = 24
+	// MinDifficulty is the lowest difficulty a blockchain or a block may claim.
+	MinDifficulty = 8
+	// MaxDifficulty is the highest difficulty a blockchain or a block may claim.
+	MaxDifficulty = 64
+)
+
 // ProofOfWork represents a proof of work algorithem
 type ProofOfWork struct {
 	block  *Block
 	target *big.Int
+	// targetBytes is target as a big endian fixed-size array, hashes are
+	// compared against it without converting them to big.Int.
+	targetBytes [sha256.Size]byte
 }
 
-// NewProofOfWork constructs a new struct of type ProofOfWork.
+// NewProofOfWork constructs a new struct of type ProofOfWork
+// for the difficulty recorded in the block header. Blocks claiming a difficulty
+// out of [MinDifficulty, MaxDifficulty] get a zero target, which no hash meets.
 func NewProofOfWork(b *Block) *ProofOfWork {
+	if !ValidDifficulty(b.Difficulty()) {
+		return &ProofOfWork{block: b, target: big.NewInt(0)}
+	}
+
 	target := big.NewInt(1)
-	// Shift the one by (shaLength-targetBits) times.
-	target.Lsh(target, uint(shaLength-targetBits))
+	// Shift the one by (shaLength-difficulty) times.
+	target.Lsh(target, uint(shaLength-b.Difficulty()))
 
-	pow := &ProofOfWork{b, target}
+	pow := &ProofOfWork{block: b, target: target}
+	targetBytes := target.Bytes()
+	copy(pow.targetBytes[len(pow.targetBytes)-len(targetBytes):], targetBytes)
 
 	return pow
 }
 
-func (pow *ProofOfWork) prepareData(nonce int) []byte {
-	data := bytes.Join(
+// header returns the part of the hashed data which does not depend on the nonce.
+func (pow *ProofOfWork) header() []byte {
+	return bytes.Join(
 		[][]byte{
 			pow.block.Spec.PrevBlockHash,
-			[]byte(pow.block.Spec.Data),
+			pow.block.HeaderData(),
 			IntToByteArray(pow.block.Spec.Timestamp),
-			IntToByteArray(int64(targetBits)),
+			IntToByteArray(pow.block.Difficulty()),
+		},
+		[]byte{},
+	)
+}
+
+func (pow *ProofOfWork) prepareData(nonce int) []byte {
+	data := bytes.Join(
+		[][]byte{
+			pow.header(),
 			IntToByteArray(int64(nonce)),
 		},
 		[]byte{},
@@ -61,43 +94,69 @@ func (pow *ProofOfWork) prepareData(nonce int) []byte {
 	return data
 }
 
+// ErrInvalidDifficulty is returned when mining a block claiming a difficulty out of
+// [MinDifficulty, MaxDifficulty].
+var ErrInvalidDifficulty = errors.New("the difficulty of the block is out of bounds")
+
+// ErrNonceSpaceExhausted is returned when no nonce yields a valid hash.
+var ErrNonceSpaceExhausted = errors.New("exhausted the nonce space without finding a valid hash")
+
 // Run creates the hash for the new block returning the
-// hash and nonce for the block.
-func (pow *ProofOfWork) Run() (int, []byte) {
-	var hashInt big.Int
-	var hash [32]byte
-	nonce := 0
-
-	fmt.Printf("\nMining block containing \"%s\"\n", pow.block.Spec.Data)
-
-	for nonce < maxNonce {
-		data := pow.prepareData(nonce)
-		hash = sha256.Sum256(data)
-		fmt.Printf("\r%x", hash)
-		hashInt.SetBytes(hash[:])
-
-		if hashInt.Cmp(pow.target) == -1 {
-			break
-		} else {
-			nonce++
-		}
-	}
-	fmt.Print("\n\n")
+// hash and nonce for the block. Run stops as soon as ctx is done, in which
+// case it returns the number of nonces tried so far and the context error.
+func (pow *ProofOfWork) Run(ctx context.Context) (int, []byte, error) {
+	return pow.RunParallel(ctx, 1)
+}
 
-	return nonce, hash[:]
+// RunParallel is like Run but splits the nonce space into as many contiguous
+// ranges as workers and searches them concurrently. All the workers stop as soon
+// as one of them finds a valid hash. On cancellation the number of nonces tried
+// by all the workers together is returned with the context error.
+func (pow *ProofOfWork) RunParallel(ctx context.Context, workers int) (int, []byte, error) {
+	glog.Infof("Mining block %s/%s with %d workers", pow.block.Namespace, pow.block.Name, workers)
+	miner := &Miner{Workers: workers}
+	return miner.Run(ctx, pow)
 }
 
 // Validate validates the data in the block is consistent with blockchain PoW algorithem.
+// Blocks claiming a difficulty out of [MinDifficulty, MaxDifficulty] are never valid.
 func (pow *ProofOfWork) Validate() bool {
-	var hashInt big.Int
+	if !ValidDifficulty(pow.block.Difficulty()) {
+		return false
+	}
 
 	data := pow.prepareData(pow.block.Spec.Nonce)
 	hash := sha256.Sum256(data)
-	hashInt.SetBytes(hash[:])
 
-	isValid := hashInt.Cmp(pow.target) == -1
+	return hashBelowTarget(&hash, &pow.targetBytes)
+}
+
+// Hash returns the hash of the block header with the nonce of the block.
+func (pow *ProofOfWork) Hash() []byte {
+	hash := sha256.Sum256(pow.prepareData(pow.block.Spec.Nonce))
+	return hash[:]
+}
+
+// hashBelowTarget compares two big endian numbers of the size of a hash.
+func hashBelowTarget(hash, target *[sha256.Size]byte) bool {
+	for i := range hash {
+		if hash[i] != target[i] {
+			return hash[i] < target[i]
+		}
+	}
+	return false
+}
+
+// ValidDifficulty reports whether difficulty is within [MinDifficulty, MaxDifficulty].
+func ValidDifficulty(difficulty int64) bool {
+	return difficulty >= MinDifficulty && difficulty <= MaxDifficulty
+}
 
-	return isValid
+// Work returns the expected number of hashes needed to find a block hash
+// with the given number of leading zero bits.
+func Work(bits int64) *big.Int {
+	work := big.NewInt(1)
+	return work.Lsh(work, uint(bits))
 }
 
 // IntToByteArray converts an int64 to a byte array
@@ -0,0 +1,107 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestHashBelowTarget(t *testing.T) {
+	var target [sha256.Size]byte
+	target[1] = 0x10
+
+	hash := target
+	if hashBelowTarget(&hash, &target) {
+		t.Fatal("expected a hash equal to the target not to be below it")
+	}
+	hash[1], hash[2] = 0x0f, 0xff
+	if !hashBelowTarget(&hash, &target) {
+		t.Fatal("expected a smaller leading byte to win over the following ones")
+	}
+	hash[1], hash[31] = 0x10, 0x01
+	if hashBelowTarget(&hash, &target) {
+		t.Fatal("expected a larger trailing byte to be above the target")
+	}
+}
+
+func TestSearchMatchesValidate(t *testing.T) {
+	block := &Block{Spec: BlockSpec{PrevBlockHash: []byte("parent"), Timestamp: 42, Difficulty: MinDifficulty}}
+	pow := NewProofOfWork(block)
+
+	var hashes uint64
+	result := search(context.Background(), pow.header(), &pow.targetBytes, 0, 1<<20, &hashes)
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+	// The nonce encoded in place by the hot loop hashes like the one of prepareData.
+	block.Spec.Nonce = result.nonce
+	if !pow.Validate() {
+		t.Fatalf("nonce %d found by the miner does not validate", result.nonce)
+	}
+	if !bytes.Equal(result.hash, pow.Hash()) {
+		t.Fatalf("expected hash %x, got %x", pow.Hash(), result.hash)
+	}
+	if hashes != uint64(result.nonce)+1 {
+		t.Fatalf("expected %d hashes, got %d", result.nonce+1, hashes)
+	}
+}
+
+func TestSearchExhausted(t *testing.T) {
+	var target [sha256.Size]byte
+	var hashes uint64
+	result := search(context.Background(), []byte("header"), &target, 10, 20, &hashes)
+	if result.err != ErrNonceSpaceExhausted || hashes != 10 {
+		t.Fatalf("expected to exhaust 10 nonces, got %v after %d hashes", result.err, hashes)
+	}
+}
+
+func TestSearchDoesNotAllocate(t *testing.T) {
+	// No hash is below a zero target, every nonce of the range is tried.
+	var target [sha256.Size]byte
+	var hashes uint64
+	header := []byte("header")
+	allocs := testing.AllocsPerRun(10, func() {
+		search(context.Background(), header, &target, 0, 10*cancelCheckInterval, &hashes)
+	})
+	// The data buffer is the only allocation, the nonces are hashed in place.
+	if allocs > 1 {
+		t.Fatalf("expected a single allocation per search, got %v", allocs)
+	}
+}
+
+func TestMinerRunInvalidDifficulty(t *testing.T) {
+	for _, difficulty := range []int64{-1, MinDifficulty - 1, MaxDifficulty + 1, 300} {
+		pow := NewProofOfWork(&Block{Spec: BlockSpec{Difficulty: difficulty}})
+		if _, _, err := (&Miner{Workers: 1}).Run(context.Background(), pow); err != ErrInvalidDifficulty {
+			t.Errorf("difficulty %d: expected error %v, got %v", difficulty, ErrInvalidDifficulty, err)
+		}
+		if pow.Validate() {
+			t.Errorf("difficulty %d: expected the block not to validate", difficulty)
+		}
+	}
+}
+
+func TestMinerRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	block := &Block{Spec: BlockSpec{PrevBlockHash: []byte("parent"), Difficulty: MaxDifficulty}}
+	if _, _, err := (&Miner{Workers: 4}).Run(ctx, NewProofOfWork(block)); err != context.Canceled {
+		t.Fatalf("expected error %v, got %v", context.Canceled, err)
+	}
+}
@@ -31,7 +31,7 @@ const GroupVersion = "v1alpha1"
 var SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: GroupVersion}
 
 var (
-	// SchemeBuilder adds the new CRDs Block and Blockchain.
+	// SchemeBuilder adds the new CRDs Block, Blockchain and Transaction.
 	SchemeBuilder = runtime.NewSchemeBuilder(AddKnownTypes)
 	// AddToScheme uses SchemeBuilder to add new CRDs.
 	AddToScheme = SchemeBuilder.AddToScheme
@@ -42,7 +42,12 @@ func AddKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
 		&Block{},
 		&BlockList{},
+		&Blockchain{},
+		&BlockchainList{},
 	)
+	// Transaction is already the entry as carried by a block.
+	scheme.AddKnownTypeWithName(SchemeGroupVersion.WithKind("Transaction"), &TransactionRequest{})
+	scheme.AddKnownTypeWithName(SchemeGroupVersion.WithKind("TransactionList"), &TransactionRequestList{})
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
 }
@@ -0,0 +1,107 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"math"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The most a single retarget may change the work required per block, as in
+// bitcoin: at most 4x easier or harder, which is two bits of difficulty.
+const maxRetargetFactor = 4
+
+// RetargetPolicy adjusts the difficulty of a blockchain every Interval blocks so
+// blocks are mined, on average, every TargetBlockTime.
+type RetargetPolicy struct {
+	// Interval is the number of blocks between two adjustments.
+	Interval int64 `json:"interval"`
+	// TargetBlockTime is the desired time between two blocks.
+	TargetBlockTime metav1.Duration `json:"targetBlockTime"`
+}
+
+// RequiredDifficulty returns the difficulty the chain rules require from the
+// block at the given height, which must not be above the height of the next block.
+// Without a retarget policy this is the difficulty set on the blockchain. With one,
+// the first blocks use the difficulty set on the blockchain and then, every
+// Interval blocks, the difficulty is adjusted from the time the last Interval
+// blocks took to mine.
+func (bc *Blockchain) RequiredDifficulty(height int64) int64 {
+	initial := bc.Spec.Difficulty
+	if initial == 0 {
+		initial = DefaultDifficulty
+	}
+
+	policy := bc.Spec.Retarget
+	if policy == nil || policy.Interval <= 0 || height == 0 {
+		return initial
+	}
+	if height > int64(len(bc.Chain)) {
+		height = int64(len(bc.Chain))
+	}
+	if height == 0 {
+		return initial
+	}
+
+	previous := bc.Chain[height-1].Difficulty()
+	if height%policy.Interval != 0 || height < policy.Interval {
+		return previous
+	}
+
+	// Measure the Interval gaps between the last Interval blocks and the block before
+	// them. The first window has no block before it and only spans Interval-1 gaps.
+	gaps := policy.Interval
+	start := height - policy.Interval - 1
+	if start < 0 {
+		gaps, start = policy.Interval-1, 0
+	}
+	if gaps == 0 {
+		return previous
+	}
+	first := bc.Chain[start]
+	last := bc.Chain[height-1]
+	actual := time.Duration(last.Spec.Timestamp-first.Spec.Timestamp) * time.Second
+	expected := time.Duration(gaps) * policy.TargetBlockTime.Duration
+	return Retarget(previous, actual, expected)
+}
+
+// Retarget returns the difficulty which makes blocks that took actual to mine
+// at the given difficulty take about expected. The change is clamped to
+// maxRetargetFactor in either direction and to [MinDifficulty, MaxDifficulty].
+func Retarget(difficulty int64, actual, expected time.Duration) int64 {
+	if expected <= 0 {
+		return difficulty
+	}
+	if actual < expected/maxRetargetFactor {
+		actual = expected / maxRetargetFactor
+	}
+	if actual > expected*maxRetargetFactor {
+		actual = expected * maxRetargetFactor
+	}
+
+	// Every bit of difficulty doubles the work required per block.
+	delta := int64(math.Floor(math.Log2(float64(expected)/float64(actual)) + 0.5))
+	next := difficulty + delta
+	if next < MinDifficulty {
+		next = MinDifficulty
+	}
+	if next > MaxDifficulty {
+		next = MaxDifficulty
+	}
+	return next
+}
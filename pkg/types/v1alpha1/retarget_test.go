@@ -0,0 +1,94 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// chainOf returns a blockchain retargeting every interval blocks toward one block every
+// minute, whose blocks were mined at difficulty 20 every spacing.
+func chainOf(blocks int, interval int64, spacing time.Duration) *Blockchain {
+	bc := &Blockchain{Spec: BlockchainSpec{
+		Difficulty: 20,
+		Retarget:   &RetargetPolicy{Interval: interval, TargetBlockTime: metav1.Duration{Duration: time.Minute}},
+	}}
+	for i := 0; i < blocks; i++ {
+		bc.Chain = append(bc.Chain, &Block{Spec: BlockSpec{
+			Timestamp:  int64(time.Duration(i) * spacing / time.Second),
+			Difficulty: 20,
+		}})
+	}
+	return bc
+}
+
+func TestRequiredDifficulty(t *testing.T) {
+	tests := []struct {
+		name     string
+		chain    *Blockchain
+		height   int64
+		expected int64
+	}{
+		{"no retarget", &Blockchain{Spec: BlockchainSpec{Difficulty: 12}}, 7, 12},
+		{"default difficulty", &Blockchain{}, 0, DefaultDifficulty},
+		{"genesis", chainOf(0, 4, time.Minute), 0, 20},
+		{"empty chain above genesis", chainOf(0, 4, time.Minute), 3, 20},
+		{"between retargets", chainOf(6, 4, time.Second), 6, 20},
+		{"first window on target", chainOf(4, 4, time.Minute), 4, 20},
+		{"window on target", chainOf(8, 4, time.Minute), 8, 20},
+		{"short window on target", chainOf(4, 2, time.Minute), 4, 20},
+		{"window twice too fast", chainOf(8, 4, 30*time.Second), 8, 21},
+		{"window twice too slow", chainOf(8, 4, 2*time.Minute), 8, 19},
+		{"clamped to 4x", chainOf(8, 4, time.Second), 8, 22},
+		{"interval of one", chainOf(1, 1, time.Second), 1, 20},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if difficulty := test.chain.RequiredDifficulty(test.height); difficulty != test.expected {
+				t.Fatalf("expected difficulty %d, got %d", test.expected, difficulty)
+			}
+		})
+	}
+}
+
+func TestRetarget(t *testing.T) {
+	tests := []struct {
+		name       string
+		difficulty int64
+		actual     time.Duration
+		expected   time.Duration
+		next       int64
+	}{
+		{"on target", 20, time.Hour, time.Hour, 20},
+		{"no expected time", 20, time.Hour, 0, 20},
+		{"twice too fast", 20, 30 * time.Minute, time.Hour, 21},
+		{"twice too slow", 20, 2 * time.Hour, time.Hour, 19},
+		{"clamped harder", 20, time.Second, time.Hour, 22},
+		{"clamped easier", 20, 100 * time.Hour, time.Hour, 18},
+		{"min difficulty", MinDifficulty, 2 * time.Hour, time.Hour, MinDifficulty},
+		{"max difficulty", MaxDifficulty, 30 * time.Minute, time.Hour, MaxDifficulty},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if next := Retarget(test.difficulty, test.actual, test.expected); next != test.next {
+				t.Fatalf("expected difficulty %d, got %d", test.next, next)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// ErrInvalidMerkleRoot is returned when the merkle root in a block header does not
+// commit to the transactions of the block.
+var ErrInvalidMerkleRoot = errors.New("the merkle root of the block does not match its transactions")
+
+// MaxDataSize is the maximum size of the free-form data of a block or of one of its
+// transactions, in bytes.
+const MaxDataSize = 16 * 1024
+
+// Transaction is a single ledger entry carried by a block. On chains without a
+// ledger it is a free-form entry, on UTXO chains it transfers value by spending
+// the outputs of earlier transactions into new outputs and on account chains it
+// transfers value between accounts.
+type Transaction struct {
+	// ID is the hash of the transaction, it is set by the controller.
+	ID   []byte `json:"id,omitempty"`
+	Data string `json:"data"`
+	// Inputs are the outputs of earlier transactions spent by the transaction.
+	Inputs []TxInput `json:"inputs,omitempty"`
+	// Outputs are the values the transaction pays and who they are paid to.
+	Outputs []TxOutput `json:"outputs,omitempty"`
+	// Transfer moves value between accounts on account chains.
+	Transfer *Transfer `json:"transfer,omitempty"`
+}
+
+// Transfer moves value from the account of a wallet to another account.
+type Transfer struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount int64  `json:"amount"`
+	// Nonce is the number of transfers previously sent from the account, so each
+	// transfer can only be applied once.
+	Nonce uint64 `json:"nonce"`
+	// PubKey is the public key of the wallet of the account, its hash must match From.
+	PubKey []byte `json:"pubKey,omitempty"`
+	// Signature is the signature of the SigningHash of the transaction by the wallet.
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// TxInput references the output of an earlier transaction spent by a transaction.
+type TxInput struct {
+	// TxID is the ID of the transaction holding the spent output.
+	TxID []byte `json:"txid"`
+	// Vout is the index of the spent output among the outputs of that transaction.
+	Vout int `json:"vout"`
+	// Signature is the signature of the SigningHash of the transaction by the owner of
+	// the spent output.
+	Signature []byte `json:"signature,omitempty"`
+	// PubKey is the public key of the owner of the spent output, its hash must
+	// match the address the output was paid to.
+	PubKey []byte `json:"pubKey,omitempty"`
+}
+
+// TxOutput is a value paid to an address, it can be spent once by a later transaction.
+type TxOutput struct {
+	Value   int64  `json:"value"`
+	Address string `json:"address"`
+}
+
+// UnspentOutput is an output which was not spent yet.
+type UnspentOutput struct {
+	TxID []byte `json:"txid"`
+	Vout int    `json:"vout"`
+	TxOutput
+}
+
+// NewCoinbase returns the transaction paying the reward of the block at the given
+// height to address. The height keeps the coinbases of different blocks distinct.
+func NewCoinbase(address string, reward, height int64) Transaction {
+	return Transaction{
+		Data:    fmt.Sprintf("Reward to %s for the block at height %d", address, height),
+		Outputs: []TxOutput{{Value: reward, Address: address}},
+	}
+}
+
+// Hash returns the hash of the content of the transaction, which is its ID.
+func (tx *Transaction) Hash() []byte {
+	content := *tx
+	content.ID = nil
+	encoded, err := json.Marshal(&content)
+	if err != nil {
+		log.Panic(err)
+	}
+	hash := sha256.Sum256(encoded)
+	return hash[:]
+}
+
+// SigningHash returns the hash the inputs of the transaction sign, that is the hash of
+// its content without the signatures.
+func (tx *Transaction) SigningHash() []byte {
+	content := *tx
+	content.Inputs = make([]TxInput, len(tx.Inputs))
+	for i, input := range tx.Inputs {
+		input.Signature = nil
+		content.Inputs[i] = input
+	}
+	if tx.Transfer != nil {
+		transfer := *tx.Transfer
+		transfer.Signature = nil
+		content.Transfer = &transfer
+	}
+	return content.Hash()
+}
+
+// Transactions returns the transactions carried by the block. Blocks which only
+// set the free-form Data carry it as a single transaction.
+func (b *Block) Transactions() []Transaction {
+	if len(b.Spec.Transactions) == 0 && b.Spec.Data != "" {
+		return []Transaction{{Data: b.Spec.Data}}
+	}
+	return b.Spec.Transactions
+}
+
+// TransactionIndex returns the index of the transaction of the block designated by
+// entry, either its index or its ID in hex.
+func (b *Block) TransactionIndex(entry string) (int, error) {
+	transactions := b.Transactions()
+	if index, err := strconv.Atoi(entry); err == nil {
+		if index < 0 || index >= len(transactions) {
+			return 0, fmt.Errorf("block %s/%s has no transaction %d", b.Namespace, b.Name, index)
+		}
+		return index, nil
+	}
+	id, err := hex.DecodeString(entry)
+	if err != nil {
+		return 0, fmt.Errorf("expected a transaction index or ID, got %q", entry)
+	}
+	for i := range transactions {
+		if bytes.Equal(transactions[i].Hash(), id) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("block %s/%s has no transaction %s", b.Namespace, b.Name, entry)
+}
+
+// ComputeMerkleRoot returns the root of the merkle tree over the hashes of the
+// transactions of the block, it is empty for a block without transactions.
+func (b *Block) ComputeMerkleRoot() []byte {
+	transactions := b.Transactions()
+	if len(transactions) == 0 {
+		return nil
+	}
+	leaves := make([][]byte, len(transactions))
+	for i := range transactions {
+		leaves[i] = transactions[i].Hash()
+	}
+	return NewMerkleTree(leaves).Root()
+}
+
+// SetMerkleRoot sets the IDs of the transactions of the block and the merkle root
+// committing to them into its header, which must be done before sealing it.
+func (b *Block) SetMerkleRoot() {
+	for i := range b.Spec.Transactions {
+		b.Spec.Transactions[i].ID = b.Spec.Transactions[i].Hash()
+	}
+	b.Spec.MerkleRoot = b.ComputeMerkleRoot()
+}
+
+// ValidateMerkleRoot checks the merkle root in the header of the block commits to
+// its transactions. Blocks sealed before merkle roots were introduced have none
+// and commit to their Data directly.
+func (b *Block) ValidateMerkleRoot() error {
+	// The transactions of pruned blocks are gone, their seal still commits to the root.
+	if b.Status.Phase == BlockPruned || (len(b.Spec.MerkleRoot) == 0 && len(b.Spec.Transactions) == 0) {
+		return nil
+	}
+	if !bytes.Equal(b.Spec.MerkleRoot, b.ComputeMerkleRoot()) {
+		return ErrInvalidMerkleRoot
+	}
+	for i := range b.Spec.Transactions {
+		if id := b.Spec.Transactions[i].ID; len(id) > 0 && !bytes.Equal(id, b.Spec.Transactions[i].Hash()) {
+			return ErrInvalidMerkleRoot
+		}
+	}
+	return nil
+}
+
+// HeaderData returns the part of the header committing to the content of the
+// block, the merkle root or, for blocks sealed before merkle roots were
+// introduced, the free-form Data.
+func (b *Block) HeaderData() []byte {
+	if len(b.Spec.MerkleRoot) > 0 {
+		return b.Spec.MerkleRoot
+	}
+	return []byte(b.Spec.Data)
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TransactionRequest is a ledger entry submitted by a user, it is served as the
+// "Transaction" kind. The controller holds pending requests in its mempool and
+// batches them into blocks, see Transaction for the entry as carried by a block.
+type TransactionRequest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              TransactionRequestSpec   `json:"spec"`
+	Status            TransactionRequestStatus `json:"status,omitempty"`
+}
+
+// TransactionRequestSpec provides specifications for the transaction.
+type TransactionRequestSpec struct {
+	// Chain is the name of the blockchain, in the same namespace, the transaction is
+	// added to, DefaultChainName is used when it is not set.
+	Chain string `json:"chain,omitempty"`
+	// Data is the free-form entry.
+	Data string `json:"data,omitempty"`
+	// Inputs are the outputs spent by the transaction on UTXO chains.
+	Inputs []TxInput `json:"inputs,omitempty"`
+	// Outputs are the values paid by the transaction on UTXO chains.
+	Outputs []TxOutput `json:"outputs,omitempty"`
+	// Transfer is the value moved by the transaction on account chains.
+	Transfer *Transfer `json:"transfer,omitempty"`
+}
+
+// ChainName returns the name of the blockchain the transaction is added to.
+func (tr *TransactionRequest) ChainName() string {
+	if tr.Spec.Chain == "" {
+		return DefaultChainName
+	}
+	return tr.Spec.Chain
+}
+
+// Transaction returns the entry as carried by a block.
+func (tr *TransactionRequest) Transaction() Transaction {
+	tx := Transaction{Data: tr.Spec.Data, Inputs: tr.Spec.Inputs, Outputs: tr.Spec.Outputs, Transfer: tr.Spec.Transfer}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+// TransactionPhase is a label for where a transaction is in its lifecycle.
+type TransactionPhase string
+
+const (
+	// TransactionPending means the transaction is held in the mempool waiting to be batched into a block.
+	// A transaction with an empty phase is considered pending.
+	TransactionPending TransactionPhase = "Pending"
+	// TransactionBatched means the transaction was batched into a block which is not mined yet.
+	TransactionBatched TransactionPhase = "Batched"
+	// TransactionIncluded means the block the transaction was batched into was mined and
+	// is part of the blockchain.
+	TransactionIncluded TransactionPhase = "Included"
+	// TransactionFailed means the transaction is invalid and is never batched.
+	TransactionFailed TransactionPhase = "Failed"
+)
+
+// TransactionRequestStatus is the observed state of the transaction, as set by the controller.
+type TransactionRequestStatus struct {
+	Phase TransactionPhase `json:"phase,omitempty"`
+	// Block is the name of the block the transaction was batched into.
+	Block string `json:"block,omitempty"`
+	// Height is the height of the block once it is mined.
+	Height int64 `json:"height,omitempty"`
+	// ID is the ID of the transaction within the block, it can be used to prove the
+	// transaction is part of the block, see MerkleProof.
+	ID []byte `json:"id,omitempty"`
+	// Message is a human readable explanation of the phase, e.g. why the transaction failed.
+	Message            string `json:"message,omitempty"`
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+}
+
+// TransactionRequestList is a list of transactions.
+type TransactionRequestList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []TransactionRequest `json:"items"`
+}
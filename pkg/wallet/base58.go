@@ -0,0 +1,75 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+)
+
+// The bitcoin base58 alphabet, which leaves out characters that look alike (0, O, I and l).
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var errInvalidBase58 = errors.New("invalid base58 string")
+
+// Base58Encode encodes input in base58, the leading zero bytes are encoded as leading '1's.
+func Base58Encode(input []byte) string {
+	var result []byte
+
+	x := new(big.Int).SetBytes(input)
+	base := big.NewInt(int64(len(base58Alphabet)))
+	zero := big.NewInt(0)
+	mod := new(big.Int)
+
+	for x.Cmp(zero) != 0 {
+		x.DivMod(x, base, mod)
+		result = append(result, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range input {
+		if b != 0x00 {
+			break
+		}
+		result = append(result, base58Alphabet[0])
+	}
+
+	// The digits were produced least significant first.
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return string(result)
+}
+
+// Base58Decode decodes a base58 string encoded by Base58Encode.
+func Base58Decode(input string) ([]byte, error) {
+	result := new(big.Int)
+	base := big.NewInt(int64(len(base58Alphabet)))
+	zeros := 0
+	for zeros < len(input) && input[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+
+	for i := 0; i < len(input); i++ {
+		digit := bytes.IndexByte([]byte(base58Alphabet), input[i])
+		if digit < 0 {
+			return nil, errInvalidBase58
+		}
+		result.Mul(result, base)
+		result.Add(result, big.NewInt(int64(digit)))
+	}
+
+	return append(make([]byte, zeros), result.Bytes()...), nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wallet
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBase58RoundTrip(t *testing.T) {
+	vectors := map[string]string{
+		"":             "",
+		"\x00":         "1",
+		"\x00\x00\x01": "112",
+		"\x39":         "z",
+		"\x3a":         "21",
+		"hello world":  "StV1DL6CwTryKyV",
+	}
+	for decoded, encoded := range vectors {
+		if got := Base58Encode([]byte(decoded)); got != encoded {
+			t.Errorf("encoding %x: expected %q, got %q", decoded, encoded, got)
+		}
+		got, err := Base58Decode(encoded)
+		if err != nil {
+			t.Errorf("decoding %q: %v", encoded, err)
+		} else if !bytes.Equal(got, []byte(decoded)) {
+			t.Errorf("decoding %q: expected %x, got %x", encoded, decoded, got)
+		}
+	}
+}
+
+func TestBase58DecodeLookalikes(t *testing.T) {
+	// 0, O, I and l are left out of the alphabet.
+	for _, input := range []string{"0", "O", "I", "l", "2l", "abc def"} {
+		if _, err := Base58Decode(input); err != errInvalidBase58 {
+			t.Errorf("decoding %q: expected error %v, got %v", input, errInvalidBase58, err)
+		}
+	}
+}
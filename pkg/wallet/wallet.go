@@ -0,0 +1,224 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wallet manages the ECDSA keys owning the outputs of UTXO blockchains, the
+// addresses derived from them and the signatures of the transactions spending them.
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+	"golang.org/x/crypto/ripemd160"
+)
+
+const (
+	// The version byte prepended to the hash of the public key in addresses.
+	version = byte(0x00)
+	// The length of the checksum appended to addresses.
+	checksumLength = 4
+)
+
+// Keys of the entries of the Secrets holding wallets.
+const (
+	// PrivateKeySecretKey holds the DER encoded ECDSA private key of the wallet.
+	PrivateKeySecretKey = "privateKey"
+	// AddressSecretKey holds the address of the wallet, for convenience.
+	AddressSecretKey = "address"
+)
+
+// ErrInvalidAddress is returned for strings which are not Base58Check encoded addresses.
+var ErrInvalidAddress = errors.New("invalid address")
+
+// Wallet is an ECDSA key pair owning the outputs paid to its address.
+type Wallet struct {
+	PrivateKey *ecdsa.PrivateKey
+	// PublicKey is the uncompressed encoding of the public key.
+	PublicKey []byte
+}
+
+// New generates a wallet with a new key pair.
+func New() (*Wallet, error) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return newWallet(private), nil
+}
+
+func newWallet(private *ecdsa.PrivateKey) *Wallet {
+	return &Wallet{
+		PrivateKey: private,
+		PublicKey:  elliptic.Marshal(private.Curve, private.X, private.Y),
+	}
+}
+
+// Address returns the address of the wallet, the Base58Check encoding of the hash of its public key.
+func (w *Wallet) Address() string {
+	payload := append([]byte{version}, HashPubKey(w.PublicKey)...)
+	return Base58Encode(append(payload, checksum(payload)...))
+}
+
+// SecretData returns the entries of the Secret holding the wallet.
+func (w *Wallet) SecretData() (map[string][]byte, error) {
+	der, err := x509.MarshalECPrivateKey(w.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{
+		PrivateKeySecretKey: der,
+		AddressSecretKey:    []byte(w.Address()),
+	}, nil
+}
+
+// FromSecretData returns the wallet held by a Secret with the given entries.
+func FromSecretData(data map[string][]byte) (*Wallet, error) {
+	der, ok := data[PrivateKeySecretKey]
+	if !ok {
+		return nil, fmt.Errorf("the secret has no %q entry", PrivateKeySecretKey)
+	}
+	private, err := x509.ParseECPrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	return newWallet(private), nil
+}
+
+// Sign signs every input of tx, which must all spend outputs paid to the wallet,
+// and its transfer, which must be sent from the account of the wallet.
+func (w *Wallet) Sign(tx *v1alpha1.Transaction) error {
+	for i := range tx.Inputs {
+		tx.Inputs[i].PubKey = w.PublicKey
+	}
+	if tx.Transfer != nil {
+		tx.Transfer.PubKey = w.PublicKey
+	}
+	hash := tx.SigningHash()
+	for i := range tx.Inputs {
+		signature, err := w.sign(hash)
+		if err != nil {
+			return err
+		}
+		tx.Inputs[i].Signature = signature
+	}
+	if tx.Transfer != nil {
+		signature, err := w.sign(hash)
+		if err != nil {
+			return err
+		}
+		tx.Transfer.Signature = signature
+	}
+	return nil
+}
+
+func (w *Wallet) sign(hash []byte) ([]byte, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, w.PrivateKey, hash)
+	if err != nil {
+		return nil, err
+	}
+	return append(pad(r.Bytes()), pad(s.Bytes())...), nil
+}
+
+// Verify checks the signature of the input of tx at index, and that its public key
+// owns the output paid to address it spends.
+func Verify(tx *v1alpha1.Transaction, index int, address string) error {
+	input := tx.Inputs[index]
+	if err := verify(tx, input.PubKey, input.Signature, address); err != nil {
+		return fmt.Errorf("input %d: %v", index, err)
+	}
+	return nil
+}
+
+// VerifyTransfer checks the signature of the transfer of tx, and that its public key
+// owns the account it is sent from.
+func VerifyTransfer(tx *v1alpha1.Transaction) error {
+	if err := verify(tx, tx.Transfer.PubKey, tx.Transfer.Signature, tx.Transfer.From); err != nil {
+		return fmt.Errorf("transfer: %v", err)
+	}
+	return nil
+}
+
+// verify checks signature is the signature of tx by pubKey, the key of address.
+func verify(tx *v1alpha1.Transaction, pubKey, signature []byte, address string) error {
+	pubKeyHash, err := PubKeyHash(address)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(HashPubKey(pubKey), pubKeyHash) {
+		return fmt.Errorf("not signed by the owner of %s", address)
+	}
+
+	x, y := elliptic.Unmarshal(elliptic.P256(), pubKey)
+	if x == nil {
+		return errors.New("invalid public key")
+	}
+	if len(signature) != 64 {
+		return errors.New("invalid signature")
+	}
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, tx.SigningHash(), r, s) {
+		return errors.New("the signature does not match the transaction")
+	}
+	return nil
+}
+
+// HashPubKey returns the RIPEMD160 of the SHA256 of a public key, which addresses encode.
+func HashPubKey(pubKey []byte) []byte {
+	sha := sha256.Sum256(pubKey)
+	hasher := ripemd160.New()
+	hasher.Write(sha[:])
+	return hasher.Sum(nil)
+}
+
+// PubKeyHash returns the hash of the public key encoded by address, ErrInvalidAddress is
+// returned when the address is malformed or its checksum does not match.
+func PubKeyHash(address string) ([]byte, error) {
+	decoded, err := Base58Decode(address)
+	if err != nil || len(decoded) != 1+ripemd160.Size+checksumLength || decoded[0] != version {
+		return nil, ErrInvalidAddress
+	}
+	payload, sum := decoded[:len(decoded)-checksumLength], decoded[len(decoded)-checksumLength:]
+	if !bytes.Equal(sum, checksum(payload)) {
+		return nil, ErrInvalidAddress
+	}
+	return payload[1:], nil
+}
+
+// ValidateAddress checks address is a Base58Check encoded address.
+func ValidateAddress(address string) error {
+	_, err := PubKeyHash(address)
+	return err
+}
+
+// checksum returns the first bytes of the double SHA256 of payload.
+func checksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	return second[:checksumLength]
+}
+
+// pad left pads a big-endian integer to 32 bytes.
+func pad(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 Nimrod Shneor <nimrodshn@gmail.com>
+// and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wallet
+
+import (
+	"testing"
+
+	v1alpha1 "github.com/nimrodshn/kubechain/pkg/types/v1alpha1"
+)
+
+func newTestWallet(t *testing.T) *Wallet {
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestValidateAddress(t *testing.T) {
+	address := newTestWallet(t).Address()
+	if err := ValidateAddress(address); err != nil {
+		t.Fatalf("expected %s to be valid, got %v", address, err)
+	}
+
+	last := address[len(address)-1]
+	other := "2"
+	if last == '2' {
+		other = "3"
+	}
+	invalid := []string{
+		"",
+		"0" + address[1:],
+		address[:len(address)-1],
+		address[:len(address)-1] + other,
+		// A pay to script hash address, which wallets do not own.
+		Base58Encode(append([]byte{0x05}, make([]byte, 24)...)),
+	}
+	for _, address := range invalid {
+		if err := ValidateAddress(address); err != ErrInvalidAddress {
+			t.Errorf("expected %q to be invalid, got %v", address, err)
+		}
+	}
+}
+
+func TestSecretDataRoundTrip(t *testing.T) {
+	w := newTestWallet(t)
+	data, err := w.SecretData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := FromSecretData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Address() != w.Address() || string(data[AddressSecretKey]) != w.Address() {
+		t.Fatalf("expected address %s, got %s restored from %s", w.Address(), restored.Address(), data[AddressSecretKey])
+	}
+	if _, err := FromSecretData(map[string][]byte{AddressSecretKey: data[AddressSecretKey]}); err == nil {
+		t.Fatal("expected an error for a secret without a private key")
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	tx := &v1alpha1.Transaction{
+		Inputs:   []v1alpha1.TxInput{{TxID: []byte("previous"), Vout: 0}},
+		Outputs:  []v1alpha1.TxOutput{{Value: 10, Address: bob.Address()}},
+		Transfer: &v1alpha1.Transfer{From: alice.Address(), To: bob.Address(), Amount: 5},
+	}
+	if err := alice.Sign(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(tx, 0, alice.Address()); err != nil {
+		t.Fatalf("expected the input to be signed by alice, got %v", err)
+	}
+	if err := VerifyTransfer(tx); err != nil {
+		t.Fatalf("expected the transfer to be signed by alice, got %v", err)
+	}
+
+	// Only the owner of the spent output can sign the input.
+	if err := Verify(tx, 0, bob.Address()); err == nil {
+		t.Fatal("expected the input not to be signed by bob")
+	}
+	// The signatures commit to the outputs and the transfer.
+	changed := &v1alpha1.Transaction{}
+	tx.DeepCopyInto(changed)
+	changed.Outputs[0].Value++
+	changed.Transfer.Amount++
+	if err := Verify(changed, 0, alice.Address()); err == nil {
+		t.Fatal("expected a changed output to invalidate the input signature")
+	}
+	if err := VerifyTransfer(changed); err == nil {
+		t.Fatal("expected a changed amount to invalidate the transfer signature")
+	}
+	// Swapping in another key breaks the link to the address.
+	changed = &v1alpha1.Transaction{}
+	tx.DeepCopyInto(changed)
+	changed.Inputs[0].PubKey = bob.PublicKey
+	if err := Verify(changed, 0, alice.Address()); err == nil {
+		t.Fatal("expected the key of bob not to own the output of alice")
+	}
+}